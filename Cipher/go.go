@@ -2,31 +2,110 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
+// shiftEnvVar names the environment variable consulted for the shift when no flag is given
+const shiftEnvVar = "CAESAR_SHIFT"
+
+// NormalizeShift reduces any shift to its equivalent in the range 0-25
+func NormalizeShift(shift int) int {
+	shift = shift % 26
+	if shift < 0 {
+		shift += 26
+	}
+	return shift
+}
+
+// InverseShift returns the shift that undoes the given one, so encrypting with
+// InverseShift(k) decrypts a message encrypted with k (and vice versa)
+func InverseShift(shift int) int {
+	return NormalizeShift(26 - NormalizeShift(shift))
+}
+
+// isASCII reports whether every byte of text is below 0x80
+func isASCII(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if text[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
 // applyCipher applies a substitution cipher with the given shift factor to the plaintext
 func applyCipher(plaintext string, shift int) string {
+	return applyDualCipher(plaintext, shift, shift)
+}
+
+// applyDualCipher shifts uppercase letters by upperShift and lowercase letters by
+// lowerShift
+func applyDualCipher(plaintext string, upperShift, lowerShift int) string {
+	// Handle negative shifts and large shifts (wraparound)
+	upperShift = NormalizeShift(upperShift)
+	lowerShift = NormalizeShift(lowerShift)
+	
+	// Pure ASCII input can be processed a byte at a time without decoding runes
+	if isASCII(plaintext) {
+		return shiftASCII(plaintext, upperShift, lowerShift)
+	}
+	return shiftRunes(plaintext, upperShift, lowerShift)
+}
+
+// shiftASCII is applyDualCipher's fast path for pure-ASCII text; the shifts must
+// already be normalized
+func shiftASCII(plaintext string, upperShift, lowerShift int) string {
 	var result strings.Builder
 	result.Grow(len(plaintext)) // Pre-allocate space for efficiency
 	
-	// Handle negative shifts and large shifts (wraparound)
-	shift = shift % 26
-	if shift < 0 {
-		shift += 26
+	for i := 0; i < len(plaintext); i++ {
+		char := plaintext[i]
+		if char >= 'A' && char <= 'Z' {
+			result.WriteByte('A' + (char-'A'+byte(upperShift))%26)
+		} else if char >= 'a' && char <= 'z' {
+			result.WriteByte('a' + (char-'a'+byte(lowerShift))%26)
+		} else {
+			result.WriteByte(char)
+		}
 	}
+	return result.String()
+}
+
+// shiftRunes is applyDualCipher's general path, decoding the text rune by rune; the
+// shifts must already be normalized
+func shiftRunes(plaintext string, upperShift, lowerShift int) string {
+	var result strings.Builder
+	result.Grow(len(plaintext)) // Pre-allocate space for efficiency
 	
 	// Process each character
 	for _, char := range plaintext {
 		if (char >= 'A' && char <= 'Z') {
 			// Handle uppercase letters
-			shifted := 'A' + (char - 'A' + rune(shift)) % 26
+			shifted := 'A' + (char - 'A' + rune(upperShift)) % 26
 			result.WriteRune(shifted)
 		} else if (char >= 'a' && char <= 'z') {
 			// Handle lowercase letters
-			shifted := 'a' + (char - 'a' + rune(shift)) % 26
+			shifted := 'a' + (char - 'a' + rune(lowerShift)) % 26
 			result.WriteRune(shifted)
 		} else {
 			// Non-alphabetic characters remain unchanged
@@ -37,20 +116,1660 @@ func applyCipher(plaintext string, shift int) string {
 	return result.String()
 }
 
-func main() {
-	scanner := bufio.NewScanner(os.Stdin)
+// ErrNonASCII is returned in strict ASCII mode when the input contains a non-ASCII rune
+var ErrNonASCII = errors.New("input contains non-ASCII characters")
+
+// checkASCII returns ErrNonASCII describing the first rune above U+007F, if any
+func checkASCII(text string) error {
+	for i, char := range text {
+		if char > unicode.MaxASCII {
+			return fmt.Errorf("%w: %q at byte %d", ErrNonASCII, char, i)
+		}
+	}
+	return nil
+}
+
+// CaseMode selects how EncryptWithOptions treats letter case
+type CaseMode int
+
+const (
+	// PreserveCase shifts upper and lower case letters separately, keeping each as it was
+	PreserveCase CaseMode = iota
+	// ForceUpper uppercases the text before shifting
+	ForceUpper
+	// ForceLower lowercases the text before shifting
+	ForceLower
+	// FoldThenRestore shifts a case-folded copy and restores each letter's original case
+	FoldThenRestore
+)
+
+// caseModeNames maps the -case flag's values to case modes
+var caseModeNames = map[string]CaseMode{
+	"preserve": PreserveCase,
+	"upper":    ForceUpper,
+	"lower":    ForceLower,
+	"fold":     FoldThenRestore,
+}
+
+// CharClass is a bitmask of the character classes EncryptWithOptions shifts
+type CharClass int
+
+const (
+	// ShiftUpper shifts the letters A-Z
+	ShiftUpper CharClass = 1 << iota
+	// ShiftLower shifts the letters a-z
+	ShiftLower
+	// ShiftDigits rotates the digits 0-9, modulo 10
+	ShiftDigits
 	
-	// Get plaintext input
-	fmt.Print("Enter plaintext: ")
-	scanner.Scan()
-	plaintext := scanner.Text()
+	// ShiftLetters is the default: both cases of letters, as applyCipher does
+	ShiftLetters = ShiftUpper | ShiftLower
+)
+
+// charClassNames maps the -classes flag's values to character classes
+var charClassNames = map[string]CharClass{
+	"upper":  ShiftUpper,
+	"lower":  ShiftLower,
+	"digits": ShiftDigits,
+}
+
+// ShiftToKeyLetter expresses a shift in textbook key-letter notation: the letter that A
+// encrypts to, so shift 0 is 'A' and shift 3 is 'D'
+func ShiftToKeyLetter(shift int) rune {
+	return 'A' + rune(NormalizeShift(shift))
+}
+
+// KeyLetterToShift converts a key letter, in either case, back to its shift from 0 to
+// 25, or returns -1 if r is not a letter A-Z
+func KeyLetterToShift(r rune) int {
+	switch {
+	case r >= 'A' && r <= 'Z':
+		return int(r - 'A')
+	case r >= 'a' && r <= 'z':
+		return int(r - 'a')
+	}
+	return -1
+}
+
+// ShiftFromPassphrase derives a shift from a passphrase by hashing it with FNV-1a, so
+// people can share something memorable instead of a number. The result is always in
+// 1-25, never the identity shift 0. This is still only a Caesar cipher with 25 possible
+// keys: the passphrase adds convenience, not security.
+func ShiftFromPassphrase(pass string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(pass))
+	return int(hash.Sum32()%25) + 1
+}
+
+// EncryptWithPassphrase encrypts with the shift derived from the passphrase
+func EncryptWithPassphrase(text, pass string) string {
+	return applyCipher(text, ShiftFromPassphrase(pass))
+}
+
+// Encrypt applies the cipher with the given shift; Decrypt in the Decipher tool takes
+// the same shift to reverse it
+func Encrypt(plaintext string, shift int) string {
+	return applyCipher(plaintext, shift)
+}
+
+// ErrRoundTrip is returned by EncryptChecked when decrypting the ciphertext does not
+// give back the original text
+var ErrRoundTrip = errors.New("ciphertext does not decrypt to the original text")
+
+// EncryptChecked encrypts the text, then decrypts the result with the inverse shift and
+// returns ErrRoundTrip if that does not reproduce the input. It is a runtime assertion
+// for callers embedding the cipher in a larger system; the extra pass roughly doubles
+// the cost of Encrypt.
+func EncryptChecked(text string, shift int) (string, error) {
+	ciphertext := applyCipher(text, shift)
+	if applyCipher(ciphertext, InverseShift(shift)) != text {
+		return "", fmt.Errorf("%w (shift %d)", ErrRoundTrip, shift)
+	}
+	return ciphertext, nil
+}
+
+// EncryptWithChecksum encrypts the text and appends "#" and the CRC-32 of the
+// plaintext as eight hex digits, so DecryptVerify can tell at once whether a shift is
+// right. The checksum is computed over the plaintext, so it lets anyone confirm a guess
+// at the message without knowing the shift, and leaks that much about its content.
+func EncryptWithChecksum(text string, shift int) string {
+	return appendChecksum(applyCipher(text, shift), text)
+}
+
+// appendChecksum appends "#" and the plaintext's CRC-32 in hex to the ciphertext
+func appendChecksum(ciphertext, plaintext string) string {
+	return fmt.Sprintf("%s#%08x", ciphertext, crc32.ChecksumIEEE([]byte(plaintext)))
+}
+
+// Options configures EncryptWithOptions; the zero value matches applyCipher
+type Options struct {
+	// StrictASCII rejects input containing any non-ASCII rune instead of passing it through
+	StrictASCII bool
 	
-	// Get shift factor
-	var shift int
-	fmt.Print("Enter shift factor (integer): ")
-	fmt.Scanln(&shift)
+	// CaseMode controls how letter case is handled (default PreserveCase)
+	CaseMode CaseMode
 	
-	// Apply cipher and output result
-	ciphertext := applyCipher(plaintext, shift)
+	// ClassesToShift selects which characters are shifted (default ShiftLetters); the
+	// rest pass through, so e.g. ShiftUpper leaves lower case letters as hints
+	ClassesToShift CharClass
+}
+
+// EncryptWithOptions applies the cipher like applyCipher, subject to the given options
+func EncryptWithOptions(plaintext string, shift int, opts Options) (string, error) {
+	if opts.StrictASCII {
+		if err := checkASCII(plaintext); err != nil {
+			return "", err
+		}
+	}
+	
+	text := plaintext
+	switch opts.CaseMode {
+	case PreserveCase, FoldThenRestore:
+	case ForceUpper:
+		text = strings.ToUpper(text)
+	case ForceLower:
+		text = strings.ToLower(text)
+	default:
+		return "", fmt.Errorf("unknown case mode %d", opts.CaseMode)
+	}
+	
+	classes := opts.ClassesToShift
+	if classes == 0 {
+		classes = ShiftLetters
+	}
+	if classes != ShiftLetters {
+		return shiftClasses(text, shift, classes), nil
+	}
+	if opts.CaseMode == FoldThenRestore {
+		return foldThenRestore(text, shift), nil
+	}
+	return applyCipher(text, shift), nil
+}
+
+// shiftClasses shifts only the characters belonging to the selected classes
+func shiftClasses(text string, shift int, classes CharClass) string {
+	var result strings.Builder
+	result.Grow(len(text))
+	
+	letterShift := rune(NormalizeShift(shift))
+	digitShift := rune((shift%10 + 10) % 10)
+	for _, char := range text {
+		switch {
+		case char >= 'A' && char <= 'Z' && classes&ShiftUpper != 0:
+			result.WriteRune('A' + (char-'A'+letterShift)%26)
+		case char >= 'a' && char <= 'z' && classes&ShiftLower != 0:
+			result.WriteRune('a' + (char-'a'+letterShift)%26)
+		case char >= '0' && char <= '9' && classes&ShiftDigits != 0:
+			result.WriteRune('0' + (char-'0'+digitShift)%10)
+		default:
+			result.WriteRune(char)
+		}
+	}
+	
+	return result.String()
+}
+
+// foldThenRestore shifts each letter as its upper-case form, then puts it back in the
+// case it had in the plaintext. Only A-Z and a-z are shifted: runes such as ı and ſ fold
+// into A-Z but are left as they are, because shifting them onto ASCII letters could not
+// be undone. For the A-Z alphabet the result therefore matches PreserveCase.
+func foldThenRestore(plaintext string, shift int) string {
+	var result strings.Builder
+	result.Grow(len(plaintext))
+	
+	letterShift := rune(NormalizeShift(shift))
+	for _, char := range plaintext {
+		upper := unicode.ToUpper(char)
+		if char >= utf8.RuneSelf || upper < 'A' || upper > 'Z' {
+			result.WriteRune(char)
+			continue
+		}
+		shifted := 'A' + (upper-'A'+letterShift)%26
+		if char != upper {
+			shifted = unicode.ToLower(shifted)
+		}
+		result.WriteRune(shifted)
+	}
+	
+	return result.String()
+}
+
+// Cipher encrypts with a fixed shift. It holds no mutable state, so a single value can
+// be shared by any number of goroutines. Each Encrypt call allocates only the returned
+// string, because applyCipher sizes its builder up front; a sync.Pool of builders
+// would not reduce that, since a strings.Builder cannot be reused once String is called.
+type Cipher struct {
+	shift int
+}
+
+// NewCipher returns a Cipher for the given shift factor
+func NewCipher(shift int) *Cipher {
+	return &Cipher{shift: NormalizeShift(shift)}
+}
+
+// Shift returns the normalized shift factor
+func (c *Cipher) Shift() int {
+	return c.shift
+}
+
+// Encrypt applies the cipher's shift to the plaintext
+func (c *Cipher) Encrypt(plaintext string) string {
+	return applyCipher(plaintext, c.shift)
+}
+
+// EncryptGrouped produces classic transmission-style ciphertext: whitespace is removed,
+// letters are uppercased and shifted, and the result is split into blocks of groupSize
+// characters separated by single spaces. It panics if groupSize is not positive.
+func EncryptGrouped(text string, shift int, groupSize int) string {
+	if groupSize <= 0 {
+		panic("EncryptGrouped: groupSize must be positive")
+	}
+	
+	// Strip whitespace and normalize case before shifting
+	compact := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, text)
+	ciphertext := []rune(applyCipher(strings.ToUpper(compact), shift))
+	
+	// Regroup into fixed-size blocks
+	var result strings.Builder
+	result.Grow(len(ciphertext) + len(ciphertext)/groupSize)
+	for i, char := range ciphertext {
+		if i > 0 && i%groupSize == 0 {
+			result.WriteByte(' ')
+		}
+		result.WriteRune(char)
+	}
+	
+	return result.String()
+}
+
+// lettersOnly strips everything except the ASCII letters A-Z and a-z
+func lettersOnly(text string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') {
+			return r
+		}
+		return -1
+	}, text)
+}
+
+// commonWords mirrors the word list the Decipher scorer uses to recognise English
+var commonWords = map[string]bool{
+	"THE": true, "BE": true, "TO": true, "OF": true, "AND": true,
+	"A": true, "IN": true, "THAT": true, "HAVE": true, "I": true,
+	"IT": true, "FOR": true, "NOT": true, "ON": true, "WITH": true,
+	"HE": true, "AS": true, "YOU": true, "DO": true, "AT": true,
+}
+
+// LooksLikePlaintext reports whether text already reads like English, which usually
+// means it is about to be encrypted by mistake (or encrypted twice). It requires at
+// least two common words making up a fifth or more of all words.
+func LooksLikePlaintext(text string) bool {
+	words := strings.Fields(strings.ToUpper(text))
+	if len(words) == 0 {
+		return false
+	}
+	
+	matches := 0
+	for _, word := range words {
+		// Clean word of non-letters
+		word = lettersOnly(word)
+		
+		if commonWords[word] {
+			matches++
+		}
+	}
+	
+	return matches >= 2 && matches*5 >= len(words)
+}
+
+// FirstOccurrencePositions returns the rune indices at which each letter appears for
+// the first time, treating upper and lower case as the same letter
+func FirstOccurrencePositions(text string) []int {
+	var seen [26]bool
+	positions := make([]int, 0, 26)
+	
+	index := 0
+	for _, char := range text {
+		letter := -1
+		if char >= 'A' && char <= 'Z' {
+			letter = int(char - 'A')
+		} else if char >= 'a' && char <= 'z' {
+			letter = int(char - 'a')
+		}
+		if letter >= 0 && !seen[letter] {
+			seen[letter] = true
+			positions = append(positions, index)
+		}
+		index++
+	}
+	
+	return positions
+}
+
+// shiftAtPositions shifts only the runes at the given rune indices
+func shiftAtPositions(text string, shift int, positions []int) string {
+	selected := make(map[int]bool, len(positions))
+	for _, pos := range positions {
+		selected[pos] = true
+	}
+	
+	var result strings.Builder
+	result.Grow(len(text))
+	
+	index := 0
+	for _, char := range text {
+		if selected[index] {
+			result.WriteString(applyCipher(string(char), shift))
+		} else {
+			result.WriteRune(char)
+		}
+		index++
+	}
+	
+	return result.String()
+}
+
+// EncryptFirstOnly shifts only the first occurrence of each letter, leaving later
+// occurrences unchanged
+func EncryptFirstOnly(text string, shift int) string {
+	return shiftAtPositions(text, shift, FirstOccurrencePositions(text))
+}
+
+// DecryptFirstOnly reverses EncryptFirstOnly. The first-occurrence positions cannot be
+// recovered from the ciphertext, because a shifted letter may collide with a later
+// unshifted one, so they must be supplied from FirstOccurrencePositions(plaintext).
+func DecryptFirstOnly(ciphertext string, shift int, positions []int) string {
+	return shiftAtPositions(ciphertext, -shift, positions)
+}
+
+// CipherDisk renders the plaintext alphabet above the shifted cipher alphabet, like a
+// paper cipher wheel
+func CipherDisk(shift int) string {
+	return CipherDiskHighlight(shift, 0)
+}
+
+// CipherDiskHighlight renders the cipher disk and, when letter is A-Z or a-z, marks its
+// column and spells out its mapping
+func CipherDiskHighlight(shift int, letter rune) string {
+	shift = NormalizeShift(shift)
+	if letter >= 'a' && letter <= 'z' {
+		letter -= 'a' - 'A'
+	}
+	
+	var plain, cipher, marker strings.Builder
+	plain.WriteString("Plain:  ")
+	cipher.WriteString("Cipher: ")
+	marker.WriteString("        ")
+	for i := 0; i < 26; i++ {
+		char := 'A' + rune(i)
+		plain.WriteRune(char)
+		plain.WriteByte(' ')
+		cipher.WriteRune('A' + rune((i+shift)%26))
+		cipher.WriteByte(' ')
+		if char == letter {
+			marker.WriteString("^ ")
+		} else {
+			marker.WriteString("  ")
+		}
+	}
+	
+	result := strings.TrimRight(plain.String(), " ") + "\n" + strings.TrimRight(cipher.String(), " ") + "\n"
+	if letter >= 'A' && letter <= 'Z' {
+		result += strings.TrimRight(marker.String(), " ") + "\n"
+		result += fmt.Sprintf("%c -> %c\n", letter, 'A'+(letter-'A'+rune(shift))%26)
+	}
+	
+	return result
+}
+
+// EncryptStruct encrypts, in place, every exported string field of the struct pointed
+// to by v that is tagged `caesar:"encrypt"`. Nested structs are walked as well; each
+// struct is encrypted once even if several pointers, or a cycle, lead back to it.
+func EncryptStruct(v interface{}, shift int) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return fmt.Errorf("EncryptStruct: expected a non-nil pointer to a struct, got %T", v)
+	}
+	value = value.Elem()
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("EncryptStruct: expected a pointer to a struct, got %T", v)
+	}
+	
+	encryptStructFields(value, shift, make(map[structAddress]bool))
+	return nil
+}
+
+// structAddress identifies a struct in memory. The type is part of the key because a
+// struct and its first field share an address.
+type structAddress struct {
+	pointer    uintptr
+	structType reflect.Type
+}
+
+// encryptStructFields applies the shift to the tagged string fields of an addressable
+// struct value, skipping structs already in visited
+func encryptStructFields(value reflect.Value, shift int, visited map[structAddress]bool) {
+	structType := value.Type()
+	address := structAddress{value.Addr().Pointer(), structType}
+	if visited[address] {
+		return
+	}
+	visited[address] = true
+	
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		info := structType.Field(i)
+		if !info.IsExported() {
+			continue
+		}
+		
+		switch field.Kind() {
+		case reflect.String:
+			if info.Tag.Get("caesar") == "encrypt" {
+				field.SetString(applyCipher(field.String(), shift))
+			}
+		case reflect.Struct:
+			encryptStructFields(field, shift, visited)
+		case reflect.Ptr:
+			if !field.IsNil() && field.Elem().Kind() == reflect.Struct {
+				encryptStructFields(field.Elem(), shift, visited)
+			}
+		}
+	}
+}
+
+// Markers delimiting an armored Caesar message
+const (
+	armorBegin = "-----BEGIN CAESAR-----"
+	armorEnd   = "-----END CAESAR-----"
+)
+
+// magicPrefix starts the first line "C<shift>" that -emit-magic adds, e.g. "C3"
+const magicPrefix = "C"
+
+// WithMagicHeader prefixes the ciphertext with a "C<shift>" line, with the shift reduced
+// to 0-25, which the Decipher tool's -magic flag reads to configure itself
+func WithMagicHeader(ciphertext string, shift int) string {
+	return fmt.Sprintf("%s%d\n%s", magicPrefix, NormalizeShift(shift), ciphertext)
+}
+
+// Armor wraps ciphertext in BEGIN/END CAESAR markers with a "Shift:" header so the
+// message describes its own key
+func Armor(ciphertext string, shift int) string {
+	return fmt.Sprintf("%s\nShift: %d\n\n%s\n%s\n", armorBegin, NormalizeShift(shift), ciphertext, armorEnd)
+}
+
+// traceCipher writes one line per input rune showing how applyCipher treats it, e.g.
+// "H(+3) -> K" for letters and "' ' passed through" for everything else
+func traceCipher(w io.Writer, plaintext string, shift int) {
+	shift = NormalizeShift(shift)
+	for _, char := range plaintext {
+		if (char >= 'A' && char <= 'Z') || (char >= 'a' && char <= 'z') {
+			fmt.Fprintf(w, "%c(+%d) -> %s\n", char, shift, applyCipher(string(char), shift))
+		} else {
+			fmt.Fprintf(w, "%q passed through\n", char)
+		}
+	}
+}
+
+// AlignedDiff lays out plaintext and ciphertext line by line with each character
+// directly above its counterpart and a row of ^ marking the positions that changed.
+// Columns follow runes rather than bytes, and wide runes such as CJK and emoji get two
+// columns so the rows stay aligned in a terminal. Tabs and other control characters
+// are drawn as "·" to keep them from breaking the layout.
+func AlignedDiff(plain, cipher string) string {
+	return alignedDiffLabeled(plain, cipher, "plain:", "cipher:")
+}
+
+// alignedDiffLabeled is AlignedDiff with custom row labels
+func alignedDiffLabeled(plain, cipher, plainLabel, cipherLabel string) string {
+	width := max(len(plainLabel), len(cipherLabel)) + 1
+	plainLines := strings.Split(plain, "\n")
+	cipherLines := strings.Split(cipher, "\n")
+	
+	var result strings.Builder
+	for i := 0; i < len(plainLines) || i < len(cipherLines); i++ {
+		var p, c []rune
+		if i < len(plainLines) {
+			p = []rune(strings.TrimSuffix(plainLines[i], "\r"))
+		}
+		if i < len(cipherLines) {
+			c = []rune(strings.TrimSuffix(cipherLines[i], "\r"))
+		}
+		
+		var plainRow, cipherRow, markRow strings.Builder
+		for j := 0; j < len(p) || j < len(c); j++ {
+			pr, cr := ' ', ' '
+			if j < len(p) {
+				pr = p[j]
+			}
+			if j < len(c) {
+				cr = c[j]
+			}
+			pr, cr = visibleRune(pr), visibleRune(cr)
+			columns := max(runeWidth(pr), runeWidth(cr))
+			
+			mark := ' '
+			if j >= len(p) || j >= len(c) || p[j] != c[j] {
+				mark = '^'
+			}
+			padRune(&plainRow, pr, columns)
+			padRune(&cipherRow, cr, columns)
+			padRune(&markRow, mark, columns)
+		}
+		
+		fmt.Fprintf(&result, "%-*s%s\n%-*s%s\n%*s%s\n", width, plainLabel, plainRow.String(), width, cipherLabel, cipherRow.String(), width, "", strings.TrimRight(markRow.String(), " "))
+	}
+	return result.String()
+}
+
+// visibleRune replaces control characters, which would break AlignedDiff's columns
+func visibleRune(r rune) rune {
+	if unicode.IsControl(r) {
+		return '·'
+	}
+	return r
+}
+
+// runeWidth estimates the terminal columns a rune occupies: two for East Asian
+// scripts and emoji, one otherwise
+func runeWidth(r rune) int {
+	if unicode.In(r, unicode.Han, unicode.Hangul, unicode.Hiragana, unicode.Katakana) || (r >= 0x1F300 && r <= 0x1FAFF) {
+		return 2
+	}
+	return 1
+}
+
+// padRune writes r and enough spaces to fill width columns
+func padRune(b *strings.Builder, r rune, width int) {
+	b.WriteRune(r)
+	b.WriteString(strings.Repeat(" ", width-runeWidth(r)))
+}
+
+// Atbash mirrors each letter within the alphabet (A<->Z, B<->Y, ...), keeping case and
+// passing everything else through. It is its own inverse.
+func Atbash(text string) string {
+	return strings.Map(func(char rune) rune {
+		switch {
+		case char >= 'A' && char <= 'Z':
+			return 'Z' - (char - 'A')
+		case char >= 'a' && char <= 'z':
+			return 'z' - (char - 'a')
+		}
+		return char
+	}, text)
+}
+
+// ROT13 is the Caesar shift of 13, which is its own inverse
+func ROT13(text string) string {
+	return applyCipher(text, 13)
+}
+
+// CaesarTransforms returns encryption and decryption with the given shift as a pair,
+// ready to pass straight to Pipeline.Add
+func CaesarTransforms(shift int) (encrypt, decrypt func(string) string) {
+	encrypt = func(text string) string { return applyCipher(text, shift) }
+	decrypt = func(text string) string { return applyCipher(text, InverseShift(shift)) }
+	return encrypt, decrypt
+}
+
+// Pipeline chains transformations, applying them in the order they were added and
+// undoing them in reverse. For example
+//
+//	var p Pipeline
+//	p.Add(Atbash, Atbash).Add(CaesarTransforms(3)).Add(ROT13, ROT13)
+//
+// encrypts with Atbash, then a shift of 3, then ROT13, and p.Undo reverses all three.
+type Pipeline struct {
+	transforms []func(string) string
+	inverses   []func(string) string
+}
+
+// Add appends a stage and the function that reverses it, and returns the pipeline so
+// calls can be chained
+func (p *Pipeline) Add(transform, inverse func(string) string) *Pipeline {
+	p.transforms = append(p.transforms, transform)
+	p.inverses = append(p.inverses, inverse)
+	return p
+}
+
+// Apply runs the text through every stage in order
+func (p *Pipeline) Apply(text string) string {
+	for _, transform := range p.transforms {
+		text = transform(text)
+	}
+	return text
+}
+
+// Undo runs the inverses from the last stage back to the first, reversing Apply
+func (p *Pipeline) Undo(text string) string {
+	for i := len(p.inverses) - 1; i >= 0; i-- {
+		text = p.inverses[i](text)
+	}
+	return text
+}
+
+// Built-in alphabets for EncryptWithAlphabet, given in upper case
+var (
+	// GreekAlphabet is the 24-letter Greek alphabet Α-Ω
+	GreekAlphabet = []rune("ΑΒΓΔΕΖΗΘΙΚΛΜΝΞΟΠΡΣΤΥΦΧΨΩ")
+	
+	// CyrillicAlphabet is the 32-letter basic Russian Cyrillic alphabet А-Я
+	CyrillicAlphabet = []rune("АБВГДЕЖЗИЙКЛМНОПРСТУФХЦЧШЩЪЫЬЭЮЯ")
+	
+	// Latin52Alphabet is A-Z followed by a-z as one 52-letter sequence
+	Latin52Alphabet = []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz")
+	
+	// URLSafeAlphabet is the 64-character URL-safe base64 alphabet A-Z, a-z, 0-9, - and _
+	URLSafeAlphabet = []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_")
+)
+
+// alphabetPresets maps the -alphabet flag's names to the built-in alphabets
+var alphabetPresets = map[string][]rune{
+	"greek":     GreekAlphabet,
+	"cyrillic":  CyrillicAlphabet,
+	"base64url": URLSafeAlphabet,
+	"latin52":   Latin52Alphabet,
+}
+
+// Errors returned by EncryptWithAlphabet and DecryptWithAlphabet for unusable alphabets
+var (
+	ErrEmptyAlphabet       = errors.New("alphabet is empty")
+	ErrDuplicateInAlphabet = errors.New("alphabet contains a duplicate letter")
+)
+
+// EncryptWithAlphabet rotates letters within the given alphabet by shift, wrapping
+// modulo its length. Lower-case forms of the alphabet's letters are rotated too and
+// keep their case; everything else passes through unchanged. Greek final sigma (ς)
+// is treated as σ, so it comes back as σ after decryption. An empty alphabet, or one
+// that repeats a letter and so could not be decrypted unambiguously, is an error.
+func EncryptWithAlphabet(text string, shift int, alphabet []rune) (string, error) {
+	if err := checkAlphabet(alphabet); err != nil {
+		return "", err
+	}
+	return rotateInAlphabet(text, shift, alphabet), nil
+}
+
+// DecryptWithAlphabet reverses EncryptWithAlphabet with the same shift and alphabet
+func DecryptWithAlphabet(text string, shift int, alphabet []rune) (string, error) {
+	return EncryptWithAlphabet(text, -shift, alphabet)
+}
+
+// checkAlphabet returns an error if the alphabet is empty or repeats a rune
+func checkAlphabet(alphabet []rune) error {
+	if len(alphabet) == 0 {
+		return ErrEmptyAlphabet
+	}
+	seen := make(map[rune]bool, len(alphabet))
+	for i, letter := range alphabet {
+		if seen[letter] {
+			return fmt.Errorf("%w: %q at position %d", ErrDuplicateInAlphabet, letter, i)
+		}
+		seen[letter] = true
+	}
+	return nil
+}
+
+// rotateInAlphabet does the work of EncryptWithAlphabet for an alphabet already known
+// to be valid, as the built-in ones are
+func rotateInAlphabet(text string, shift int, alphabet []rune) string {
+	size := len(alphabet)
+	
+	// Handle negative shifts and large shifts (wraparound)
+	shift = (shift%size + size) % size
+	
+	index := make(map[rune]int, size)
+	for i, letter := range alphabet {
+		index[letter] = i
+	}
+	
+	var result strings.Builder
+	result.Grow(len(text))
+	
+	for _, char := range text {
+		if i, ok := index[char]; ok {
+			result.WriteRune(alphabet[(i+shift)%size])
+		} else if i, ok := index[unicode.ToUpper(char)]; ok && unicode.IsLower(char) {
+			result.WriteRune(unicode.ToLower(alphabet[(i+shift)%size]))
+		} else {
+			// Characters outside the alphabet remain unchanged
+			result.WriteRune(char)
+		}
+	}
+	
+	return result.String()
+}
+
+// EncryptBase64Alphabet rotates within the URL-safe base64 alphabet, modulo 64, for
+// interoperating with tools that apply Caesar to base64url text. Both cases and the
+// digits are part of the one alphabet, so "Z" becomes "a" and "_" wraps to "A". Every
+// character of the alphabet maps to another, so input made only of base64url characters
+// always encrypts to valid base64url; anything else, such as "=" padding, passes through.
+func EncryptBase64Alphabet(text string, shift int) string {
+	return rotateInAlphabet(text, shift, URLSafeAlphabet)
+}
+
+// DecryptBase64Alphabet reverses EncryptBase64Alphabet with the same shift
+func DecryptBase64Alphabet(text string, shift int) string {
+	return rotateInAlphabet(text, -shift, URLSafeAlphabet)
+}
+
+// Encrypt52 rotates letters through the single 52-letter sequence A-Z then a-z, as some
+// other tools do, so "Z" shifted by 1 becomes "a" and "z" wraps to "A". Unlike applyCipher,
+// which rotates each case within its own 26 letters and so always preserves case, a
+// letter's case here depends on the shift. Shifts wrap modulo 52, not 26.
+func Encrypt52(text string, shift int) string {
+	return rotateInAlphabet(text, shift, Latin52Alphabet)
+}
+
+// Decrypt52 reverses Encrypt52 with the same shift
+func Decrypt52(text string, shift int) string {
+	return rotateInAlphabet(text, -shift, Latin52Alphabet)
+}
+
+// EncryptReversed reverses the text and then shifts it, as some obfuscators do.
+// DecryptReversed in the Decipher tool undoes it with the same shift.
+func EncryptReversed(text string, shift int) string {
+	return applyCipher(reverseRunes(text), shift)
+}
+
+// EncryptDualShift shifts uppercase letters by upperShift and lowercase letters by
+// lowerShift, giving 26*26 keys instead of 26. With equal shifts it is Encrypt. The
+// two shifts are still independent Caesar ciphers, so breaking each case separately
+// recovers them.
+func EncryptDualShift(text string, upperShift, lowerShift int) string {
+	return applyDualCipher(text, upperShift, lowerShift)
+}
+
+// DecryptDualShift reverses EncryptDualShift given the same pair of shifts
+func DecryptDualShift(text string, upperShift, lowerShift int) string {
+	return applyDualCipher(text, InverseShift(upperShift), InverseShift(lowerShift))
+}
+
+// reverseRunes reverses text rune by rune, so multi-byte characters stay intact.
+// Combining marks end up before the letter they modified.
+func reverseRunes(text string) string {
+	runes := []rune(text)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// EncryptRange rotates runes in the contiguous range [lo, hi] by shift, modulo the
+// range's size, and passes all other runes through. EncryptRange(text, k, 'A', 'Z') is
+// the upper-case half of applyCipher; other ranges such as box drawing or a private use
+// area work the same way. An empty range (lo > hi) leaves the text unchanged.
+func EncryptRange(text string, shift int, lo, hi rune) string {
+	if lo > hi {
+		return text
+	}
+	size := int(hi-lo) + 1
+	offset := rune((shift%size + size) % size)
+	
+	var result strings.Builder
+	result.Grow(len(text))
+	
+	for _, char := range text {
+		if char >= lo && char <= hi {
+			result.WriteRune(lo + (char-lo+offset)%rune(size))
+		} else {
+			result.WriteRune(char)
+		}
+	}
+	
+	return result.String()
+}
+
+// EncryptRegex shifts only the parts of text matched by re and copies the rest as it is,
+// so selected fields of a log line can be masked. If re has capture groups, only the
+// text they capture is shifted and the rest of each match is kept as context: with
+// `user=(\w+)` the "user=" prefix stays readable. Nested groups are shifted once.
+//
+// Matching uses Go's linear-time regexp engine, so the cost grows with the input rather
+// than blowing up on awkward patterns, but it is still several times slower than
+// Encrypt on the same text and allocates for every match.
+// Decrypting with InverseShift only works if re also matches the ciphertext.
+func EncryptRegex(text string, shift int, re *regexp.Regexp) string {
+	var result strings.Builder
+	result.Grow(len(text))
+	
+	copied := 0
+	for _, match := range re.FindAllStringSubmatchIndex(text, -1) {
+		// Pairs after the first are the groups; without any, shift the whole match
+		spans := match[2:]
+		if len(spans) == 0 {
+			spans = match[:2]
+		}
+		for len(spans) > 0 {
+			start, end := spans[0], spans[1]
+			spans = spans[2:]
+			// Skip groups that did not participate or lie inside one already shifted
+			if start < copied {
+				continue
+			}
+			result.WriteString(text[copied:start])
+			result.WriteString(applyCipher(text[start:end], shift))
+			copied = end
+		}
+	}
+	result.WriteString(text[copied:])
+	
+	return result.String()
+}
+
+// transformWords applies fn to each whitespace-delimited word, keeping the whitespace intact
+func transformWords(text string, fn func(word string) string) string {
+	var result strings.Builder
+	result.Grow(len(text))
+	
+	start := -1
+	for i, char := range text {
+		if unicode.IsSpace(char) {
+			if start >= 0 {
+				result.WriteString(fn(text[start:i]))
+				start = -1
+			}
+			result.WriteRune(char)
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		result.WriteString(fn(text[start:]))
+	}
+	
+	return result.String()
+}
+
+// EncryptPreservingWords encrypts text but leaves the listed words as they are, so
+// names and keywords stay readable. A word is a run of letters and digits, and matches
+// a listed word regardless of case: keeping "paris" leaves "Paris," alone but still
+// shifts "Parisian". Surrounding spaces in entries are ignored; entries containing
+// inner spaces or punctuation never match.
+func EncryptPreservingWords(text string, shift int, keep []string) string {
+	kept := make(map[string]bool, len(keep))
+	for _, word := range keep {
+		kept[strings.ToLower(strings.TrimSpace(word))] = true
+	}
+	
+	var result strings.Builder
+	result.Grow(len(text))
+	
+	start := -1
+	endWord := func(end int) {
+		word := text[start:end]
+		if kept[strings.ToLower(word)] {
+			result.WriteString(word)
+		} else {
+			result.WriteString(applyCipher(word, shift))
+		}
+		start = -1
+	}
+	for i, char := range text {
+		if unicode.IsLetter(char) || unicode.IsDigit(char) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			endWord(i)
+		}
+		result.WriteRune(char)
+	}
+	if start >= 0 {
+		endWord(len(text))
+	}
+	
+	return result.String()
+}
+
+// DecryptPreservingWords reverses EncryptPreservingWords given the same shift and list.
+// An encrypted word that happens to spell a listed word is left shifted, since it
+// cannot be told apart from one that was kept.
+func DecryptPreservingWords(text string, shift int, keep []string) string {
+	return EncryptPreservingWords(text, InverseShift(shift), keep)
+}
+
+// wordLengthShift derives a word's shift from the number of letters it contains.
+// Attached punctuation and digits are not counted and pass through unchanged, so
+// "Hello," and "Hello" both use shift 5.
+func wordLengthShift(word string) int {
+	return len(lettersOnly(word)) % 26
+}
+
+// EncryptByWordLength shifts each word by its own letter count (mod 26)
+func EncryptByWordLength(text string) string {
+	return transformWords(text, func(word string) string {
+		return applyCipher(word, wordLengthShift(word))
+	})
+}
+
+// DecryptByWordLength reverses EncryptByWordLength; shifting never changes a word's
+// letter count, so each word's shift can be re-measured from the ciphertext
+func DecryptByWordLength(text string) string {
+	return transformWords(text, func(word string) string {
+		return applyCipher(word, -wordLengthShift(word))
+	})
+}
+
+// inputIsPiped reports whether stdin is a file or pipe rather than a terminal
+func inputIsPiped() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice == 0
+}
+
+// inputEncoding identifies the byte encoding detected on piped input
+type inputEncoding int
+
+const (
+	encodingUTF8 inputEncoding = iota
+	encodingUTF16LE
+	encodingUTF16BE
+)
+
+// decodeInput converts input carrying a UTF-16 byte order mark to a UTF-8 string.
+// Anything without a UTF-16 BOM is treated as UTF-8 and returned unchanged.
+func decodeInput(data []byte) (string, inputEncoding, error) {
+	var order binary.ByteOrder
+	var encoding inputEncoding
+	switch {
+	case len(data) >= 2 && data[0] == 0xFF && data[1] == 0xFE:
+		order, encoding = binary.LittleEndian, encodingUTF16LE
+	case len(data) >= 2 && data[0] == 0xFE && data[1] == 0xFF:
+		order, encoding = binary.BigEndian, encodingUTF16BE
+	default:
+		return string(data), encodingUTF8, nil
+	}
+	
+	// Skip the BOM and decode the remaining code units
+	body := data[2:]
+	if len(body)%2 != 0 {
+		return "", encoding, fmt.Errorf("truncated UTF-16 input: odd number of bytes")
+	}
+	units := make([]uint16, len(body)/2)
+	for i := range units {
+		units[i] = order.Uint16(body[2*i:])
+	}
+	
+	return string(utf16.Decode(units)), encoding, nil
+}
+
+// encodeOutput encodes text in the given encoding, writing a BOM for UTF-16
+func encodeOutput(text string, encoding inputEncoding) []byte {
+	var order binary.ByteOrder
+	switch encoding {
+	case encodingUTF16LE:
+		order = binary.LittleEndian
+	case encodingUTF16BE:
+		order = binary.BigEndian
+	default:
+		return []byte(text)
+	}
+	
+	units := utf16.Encode([]rune(text))
+	out := make([]byte, 2+2*len(units))
+	order.PutUint16(out, 0xFEFF)
+	for i, unit := range units {
+		order.PutUint16(out[2+2*i:], unit)
+	}
+	
+	return out
+}
+
+// readInput returns piped stdin byte-for-byte, including any trailing newline, or
+// prompts for a single line when running interactively. Piped UTF-16 with a BOM is
+// decoded to UTF-8 and its encoding reported so output can match it.
+func readInput(scanner *bufio.Scanner, prompt string) (string, inputEncoding, error) {
+	if inputIsPiped() {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", encodingUTF8, err
+		}
+		return decodeInput(data)
+	}
+	
+	fmt.Print(prompt)
+	scanner.Scan()
+	return scanner.Text(), encodingUTF8, scanner.Err()
+}
+
+// jsonRequest is the input accepted by -json-in
+type jsonRequest struct {
+	Text  *string `json:"text"`
+	Shift *int    `json:"shift"`
+}
+
+// jsonResponse is the output written by -json-in; exactly one field is set
+type jsonResponse struct {
+	Ciphertext *string `json:"ciphertext,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// encryptJSON reads a {"text":...,"shift":...} request from r and writes either
+// {"ciphertext":...} or {"error":...} to w, returning the error it reported, if any
+func encryptJSON(r io.Reader, w io.Writer) error {
+	var req jsonRequest
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&req)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("invalid JSON request: %v", err)
+	case req.Text == nil:
+		err = fmt.Errorf("invalid JSON request: missing \"text\"")
+	case req.Shift == nil:
+		err = fmt.Errorf("invalid JSON request: missing \"shift\"")
+	}
+	
+	var resp jsonResponse
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		ciphertext := applyCipher(*req.Text, *req.Shift)
+		resp.Ciphertext = &ciphertext
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if encodeErr := encoder.Encode(resp); encodeErr != nil {
+		return encodeErr
+	}
+	return err
+}
+
+// encryptedSuffix is appended to a file's name to form its encrypted sibling
+const encryptedSuffix = ".caesar"
+
+// binarySniffLength is how many leading bytes are checked for a null byte
+const binarySniffLength = 8000
+
+// encryptStream copies r to w, shifting ASCII letters as it goes. UTF-8 continuation
+// and lead bytes are all 0x80 or above, so shifting byte by byte never splits a rune
+// and the input never has to be held in memory as a whole.
+func encryptStream(r io.Reader, w io.Writer, shift int) error {
+	shift = NormalizeShift(shift)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		chunk := buf[:n]
+		for i, char := range chunk {
+			if char >= 'A' && char <= 'Z' {
+				chunk[i] = 'A' + (char-'A'+byte(shift))%26
+			} else if char >= 'a' && char <= 'z' {
+				chunk[i] = 'a' + (char-'a'+byte(shift))%26
+			}
+		}
+		if _, writeErr := w.Write(chunk); writeErr != nil {
+			return writeErr
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// isBinaryFile applies the null-byte heuristic to the start of the file
+func isBinaryFile(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	
+	head := make([]byte, binarySniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	return bytes.IndexByte(head[:n], 0) >= 0, nil
+}
+
+// encryptFile writes the encrypted contents of path to dest
+func encryptFile(path, dest string, shift int) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if err := encryptStream(in, out, shift); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// encryptTree walks root and writes an encrypted ".caesar" sibling for every text file
+// whose extension is listed, skipping files that look binary. With dryRun it only
+// reports what it would do. Progress is written to w.
+func encryptTree(root string, shift int, extensions []string, dryRun bool, w io.Writer) error {
+	wanted := make(map[string]bool, len(extensions))
+	for _, ext := range extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext != "" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		wanted[ext] = true
+	}
+	
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || strings.HasSuffix(path, encryptedSuffix) {
+			return nil
+		}
+		if !wanted[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		
+		isBinary, err := isBinaryFile(path)
+		if err != nil {
+			return err
+		}
+		if isBinary {
+			fmt.Fprintf(w, "skipped %s (binary)\n", path)
+			return nil
+		}
+		
+		dest := path + encryptedSuffix
+		if dryRun {
+			fmt.Fprintf(w, "would encrypt %s -> %s\n", path, dest)
+			return nil
+		}
+		if err := encryptFile(path, dest, shift); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "encrypted %s -> %s\n", path, dest)
+		return nil
+	})
+}
+
+// ParseShift reads a shift written as a plain integer ("13", "-13") or in rotN form
+// ("rot13", "ROT3"), as people commonly name Caesar shifts
+func ParseShift(s string) (int, error) {
+	text := strings.TrimSpace(s)
+	if len(text) > 3 && strings.EqualFold(text[:3], "rot") {
+		text = text[3:]
+	}
+	shift, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid shift %q: want an integer such as 13 or a name such as rot13", s)
+	}
+	return shift, nil
+}
+
+// shiftValue is a flag.Value that accepts anything ParseShift does
+type shiftValue int
+
+func (v *shiftValue) String() string { return strconv.Itoa(int(*v)) }
+
+func (v *shiftValue) Set(s string) error {
+	shift, err := ParseShift(s)
+	if err != nil {
+		return err
+	}
+	*v = shiftValue(shift)
+	return nil
+}
+
+// practiceSentences are the built-in plaintexts the practice subcommand picks from when
+// none is given
+var practiceSentences = []string{
+	"The quick brown fox jumps over the lazy dog.",
+	"Veni, vidi, vici.",
+	"Julius Caesar used this cipher to protect his military messages.",
+	"Meet me by the old oak tree at midnight.",
+	"Frequency analysis reveals which letter stands for E.",
+	"A secret shared by three is no longer a secret.",
+	"The die is cast, and the legion crosses the Rubicon at dawn.",
+	"Every letter moves the same distance along the alphabet.",
+}
+
+// answerKeyShiftHeader starts the first line of an answer key file
+const answerKeyShiftHeader = "Shift: "
+
+// formatAnswerKey lays out an answer key as a "Shift: N" line, a blank line and the
+// plaintext, the same shape as an armored message's header and body
+func formatAnswerKey(shift int, plaintext string) string {
+	return fmt.Sprintf("%s%d\n\n%s\n", answerKeyShiftHeader, shift, plaintext)
+}
+
+// runPractice implements the practice subcommand: it encrypts the given plaintext, or a
+// built-in sentence, with a random shift from 1 to 25 and writes the ciphertext and an
+// answer key to separate files. The same -seed always produces the same exercise.
+func runPractice(args []string) error {
+	flags := flag.NewFlagSet("practice", flag.ExitOnError)
+	seed := flags.Int64("seed", 0, "random seed, to reproduce an exercise (default: time-based)")
+	outPath := flags.String("out", "practice.txt", "file to write the ciphertext to")
+	keyPath := flags.String("key", "practice.key", "file to write the answer key to")
+	flags.Parse(args)
+	
+	seedSet := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			seedSet = true
+		}
+	})
+	if !seedSet {
+		*seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(*seed))
+	
+	plaintext := strings.Join(flags.Args(), " ")
+	if plaintext == "" {
+		plaintext = practiceSentences[rng.Intn(len(practiceSentences))]
+	}
+	shift := rng.Intn(25) + 1
+	
+	if err := os.WriteFile(*outPath, []byte(applyCipher(plaintext, shift)+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(*keyPath, []byte(formatAnswerKey(shift, plaintext)), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote exercise to %s and answer key to %s (seed %d)\n", *outPath, *keyPath, *seed)
+	return nil
+}
+
+// parseAnswerKey reads an answer key written by formatAnswerKey
+func parseAnswerKey(key string) (shift int, plaintext string, err error) {
+	header, body, found := strings.Cut(strings.ReplaceAll(key, "\r\n", "\n"), "\n\n")
+	if !found || !strings.HasPrefix(header, answerKeyShiftHeader) {
+		return 0, "", errors.New("malformed answer key: want a \"Shift: N\" line, a blank line and the plaintext")
+	}
+	shift, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, answerKeyShiftHeader)))
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed answer key shift %q", header)
+	}
+	return shift, strings.TrimSuffix(body, "\n"), nil
+}
+
+// normalizeAnswer folds case and collapses whitespace so grading ignores both
+func normalizeAnswer(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// runGrade implements the grade subcommand: "grade submission.txt practice.key" checks a
+// student's decryption against an answer key from the practice subcommand, ignoring
+// case and whitespace, and with -shift also checks the shift they identified. It
+// reports whether the submission passed and prints a diff when it did not.
+func runGrade(args []string) (bool, error) {
+	flags := flag.NewFlagSet("grade", flag.ExitOnError)
+	claimed := new(int)
+	flags.Var((*shiftValue)(claimed), "shift", "the shift the student identified, checked against the key")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		return false, errors.New("usage: grade [-shift N] <submission> <answer key>")
+	}
+	
+	submission, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return false, err
+	}
+	key, err := os.ReadFile(flags.Arg(1))
+	if err != nil {
+		return false, err
+	}
+	shift, plaintext, err := parseAnswerKey(string(key))
+	if err != nil {
+		return false, err
+	}
+	
+	passed := true
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "shift" && NormalizeShift(*claimed) != NormalizeShift(shift) {
+			fmt.Printf("Shift %d is wrong; the key uses %d\n", *claimed, shift)
+			passed = false
+		}
+	})
+	
+	want, got := normalizeAnswer(plaintext), normalizeAnswer(string(submission))
+	if want != got {
+		fmt.Print(alignedDiffLabeled(want, got, "key:", "yours:"))
+		passed = false
+	}
+	
+	if passed {
+		fmt.Println("PASS")
+	} else {
+		fmt.Println("FAIL")
+	}
+	return passed, nil
+}
+
+// resolveShift picks the shift factor using the precedence flag > environment > prompt
+func resolveShift(scanner *bufio.Scanner, flagShift int, flagSet bool) (int, error) {
+	if flagSet {
+		return flagShift, nil
+	}
+	
+	// Fall back to the environment so the tool works without a TTY
+	if value, ok := os.LookupEnv(shiftEnvVar); ok {
+		shift, err := ParseShift(value)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", shiftEnvVar, err)
+		}
+		return shift, nil
+	}
+	
+	// Finally ask the user
+	fmt.Print("Enter shift factor (integer or rotN): ")
+	if !scanner.Scan() {
+		return 0, fmt.Errorf("no shift factor provided")
+	}
+	return ParseShift(scanner.Text())
+}
+
+// CompareShifts encrypts the same text under two shifts so the outputs can be compared
+func CompareShifts(text string, a, b int) (outA, outB string) {
+	return applyCipher(text, a), applyCipher(text, b)
+}
+
+// ShiftDistance counts the letter positions at which encrypting text with shift a and
+// with shift b give different results. Because a Caesar shift moves every letter the
+// same distance, the answer is always all or nothing: 0 when a and b are congruent
+// modulo 26, otherwise the number of letters in text.
+func ShiftDistance(text string, a, b int) int {
+	// applyCipher maps rune to rune and leaves non-letters alone, so the outputs line
+	// up and only letter positions can differ
+	outA, outB := []rune(applyCipher(text, a)), []rune(applyCipher(text, b))
+	distance := 0
+	for i := range outA {
+		if outA[i] != outB[i] {
+			distance++
+		}
+	}
+	return distance
+}
+
+// runCompare implements the compare subcommand: "compare A B" encrypts the input under
+// both shifts and prints the results one above the other
+func runCompare(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: compare <shiftA> <shiftB>")
+	}
+	a, err := ParseShift(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := ParseShift(args[1])
+	if err != nil {
+		return err
+	}
+	
+	text, _, err := readInput(bufio.NewScanner(os.Stdin), "Enter plaintext: ")
+	if err != nil {
+		return err
+	}
+	text = strings.TrimRight(text, "\r\n")
+	
+	// Pad the labels to a common width so the outputs line up
+	outA, outB := CompareShifts(text, a, b)
+	labelA, labelB := fmt.Sprintf("Shift %d:", a), fmt.Sprintf("Shift %d:", b)
+	width := len(labelA)
+	if len(labelB) > width {
+		width = len(labelB)
+	}
+	fmt.Printf("%-*s %s\n", width, "Plain:", text)
+	fmt.Printf("%-*s %s\n", width, labelA, outA)
+	fmt.Printf("%-*s %s\n", width, labelB, outB)
+	fmt.Printf("%d of %d letters differ\n", ShiftDistance(text, a, b), len(lettersOnly(text)))
+	return nil
+}
+
+func main() {
+	// Subcommands come before any flags
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		if err := runCompare(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "grade" {
+		passed, err := runGrade(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		if !passed {
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "practice" {
+		if err := runPractice(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	
+	shiftFlag := new(int)
+	flag.Var((*shiftValue)(shiftFlag), "shift", "shift factor such as 3 or rot13 (overrides "+shiftEnvVar+" and the prompt; required when stdin is piped)")
+	groupSize := flag.Int("group", 0, "emit uppercase ciphertext in blocks of this many characters (0 disables grouping)")
+	warnPlaintext := flag.Bool("warn-plaintext", false, "warn on stderr when the input already looks like English")
+	keepEncoding := flag.Bool("keep-encoding", false, "write piped output in the input's UTF-16 encoding instead of UTF-8")
+	showDisk := flag.Bool("disk", false, "print the cipher disk for the shift instead of encrypting")
+	highlight := flag.String("highlight", "", "with -disk, mark the mapping of this plaintext letter")
+	armor := flag.Bool("armor", false, "wrap the ciphertext in BEGIN/END CAESAR markers carrying the shift")
+	strictASCII := flag.Bool("strict-ascii", false, "reject input containing non-ASCII characters")
+	trace := flag.Bool("trace", false, "print each letter's shift to stderr")
+	lowerShiftFlag := new(int)
+	flag.Var((*shiftValue)(lowerShiftFlag), "lower-shift", "shift lowercase letters by this amount and uppercase letters by -shift")
+	regexPattern := flag.String("regex", "", "shift only the text matching this regular expression, or its capture groups if it has any")
+	keepWords := flag.String("keep", "", "comma-separated words to leave unencrypted, matched case-insensitively")
+	reverse := flag.Bool("reverse", false, "reverse the text before shifting it")
+	emitMagic := flag.Bool("emit-magic", false, "prefix the ciphertext with a magic header line such as C3 naming the shift")
+	showDiff := flag.Bool("diff", false, "print plaintext and ciphertext aligned, marking the changed characters")
+	alphabetName := flag.String("alphabet", "", "rotate within a built-in alphabet instead of A-Z (greek, cyrillic, base64url or latin52)")
+	caseName := flag.String("case", "preserve", "letter case handling: preserve, upper, lower or fold")
+	classNames := flag.String("classes", "upper,lower", "comma-separated character classes to shift: upper, lower, digits")
+	keyLetter := flag.String("key-letter", "", "give the shift as the letter A encrypts to (A=0, D=3) instead of -shift")
+	passphrase := flag.String("passphrase", "", "derive the shift from this passphrase instead of -shift")
+	jsonIn := flag.Bool("json-in", false, "read {\"text\":...,\"shift\":...} from stdin and write {\"ciphertext\":...}")
+	recurseDir := flag.String("recurse", "", "encrypt the text files under this directory into .caesar siblings")
+	extensions := flag.String("ext", ".txt,.md", "with -recurse, comma-separated file extensions to encrypt")
+	dryRun := flag.Bool("dry-run", false, "with -recurse, list what would be encrypted without writing anything")
+	checksum := flag.Bool("checksum", false, "append a CRC-32 of the plaintext for verification on decryption")
+	flag.Parse()
+	
+	// JSON mode carries its own text and shift, bypassing prompts entirely
+	if *jsonIn {
+		if err := encryptJSON(os.Stdin, os.Stdout); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	
+	var classes CharClass
+	for _, name := range strings.Split(*classNames, ",") {
+		class, ok := charClassNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: unknown character class %q\n", name)
+			os.Exit(1)
+		}
+		classes |= class
+	}
+	
+	caseMode, ok := caseModeNames[strings.ToLower(*caseName)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: unknown case mode %q\n", *caseName)
+		os.Exit(1)
+	}
+	
+	var regexFilter *regexp.Regexp
+	if *regexPattern != "" {
+		var err error
+		regexFilter, err = regexp.Compile(*regexPattern)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	}
+	
+	if *groupSize < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -group must not be negative")
+		os.Exit(1)
+	}
+	
+	// Record whether -shift was given explicitly, since 0 is a valid shift
+	shiftSet, lowerShiftSet := false, false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "shift":
+			shiftSet = true
+		case "lower-shift":
+			lowerShiftSet = true
+		}
+	})
+	
+	scanner := bufio.NewScanner(os.Stdin)
+	
+	// Directory mode reads files, not stdin, so only the shift is needed
+	if *recurseDir != "" {
+		shift, err := resolveShift(scanner, *shiftFlag, shiftSet)
+		if err == nil {
+			err = encryptTree(*recurseDir, shift, strings.Split(*extensions, ","), *dryRun, os.Stdout)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	
+	// The cipher disk only needs a shift, not any input text
+	if *showDisk {
+		shift, err := resolveShift(scanner, *shiftFlag, shiftSet)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		var letter rune
+		if *highlight != "" {
+			letter = []rune(*highlight)[0]
+		}
+		fmt.Print(CipherDiskHighlight(shift, letter))
+		return
+	}
+	
+	// Get plaintext input
+	plaintext, encoding, err := readInput(scanner, "Enter plaintext: ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	
+	if *strictASCII {
+		if err := checkASCII(plaintext); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	}
+	
+	// Optionally flag input that already reads as English before it is encrypted
+	if *warnPlaintext && LooksLikePlaintext(plaintext) {
+		fmt.Fprintln(os.Stderr, "Warning: input already looks like English plaintext; check it is the text you meant to encrypt.")
+	}
+	
+	// Get shift factor
+	var shift int
+	if *passphrase != "" {
+		shift = ShiftFromPassphrase(*passphrase)
+	} else if *keyLetter != "" {
+		letters := []rune(*keyLetter)
+		if len(letters) != 1 || KeyLetterToShift(letters[0]) < 0 {
+			fmt.Fprintf(os.Stderr, "Error: invalid key letter %q: want a single letter A-Z\n", *keyLetter)
+			os.Exit(1)
+		}
+		shift = KeyLetterToShift(letters[0])
+	} else {
+		shift, err = resolveShift(scanner, *shiftFlag, shiftSet)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	}
+	
+	// Show the per-letter mechanics without disturbing stdout
+	if *trace {
+		traceCipher(os.Stderr, plaintext, shift)
+	}
+	
+	// Apply cipher and output result
+	var ciphertext string
+	if *alphabetName != "" {
+		alphabet, ok := alphabetPresets[strings.ToLower(*alphabetName)]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: unknown alphabet %q\n", *alphabetName)
+			os.Exit(1)
+		}
+		ciphertext, err = EncryptWithAlphabet(plaintext, shift, alphabet)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	} else if regexFilter != nil {
+		ciphertext = EncryptRegex(plaintext, shift, regexFilter)
+	} else if *keepWords != "" {
+		ciphertext = EncryptPreservingWords(plaintext, shift, strings.Split(*keepWords, ","))
+	} else if lowerShiftSet {
+		ciphertext = EncryptDualShift(plaintext, shift, *lowerShiftFlag)
+	} else if *reverse {
+		// Keep a trailing line ending at the end rather than reversing it to the front
+		body := strings.TrimRight(plaintext, "\r\n")
+		ciphertext = EncryptReversed(body, shift) + plaintext[len(body):]
+	} else if *groupSize > 0 {
+		ciphertext = EncryptGrouped(plaintext, shift, *groupSize)
+	} else {
+		ciphertext, err = EncryptWithOptions(plaintext, shift, Options{CaseMode: caseMode, ClassesToShift: classes})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	}
+	if *showDiff {
+		fmt.Print(AlignedDiff(plaintext, ciphertext))
+		return
+	}
+	if *checksum {
+		ciphertext = appendChecksum(ciphertext, plaintext)
+	}
+	if *armor {
+		ciphertext = Armor(ciphertext, shift)
+	}
+	if *emitMagic {
+		ciphertext = WithMagicHeader(ciphertext, shift)
+	}
+	if inputIsPiped() {
+		// Emit the ciphertext verbatim so files round-trip exactly
+		if !*keepEncoding {
+			encoding = encodingUTF8
+		}
+		os.Stdout.Write(encodeOutput(ciphertext, encoding))
+		return
+	}
+	if *armor {
+		fmt.Print("Ciphertext:\n", ciphertext)
+		return
+	}
 	fmt.Println("Ciphertext:", ciphertext)
 }
\ No newline at end of file
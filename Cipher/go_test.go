@@ -0,0 +1,344 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNormalizeAndInverseShift(t *testing.T) {
+	for _, tc := range []struct{ shift, normal, inverse int }{
+		{0, 0, 0},
+		{1, 1, 25},
+		{3, 3, 23},
+		{13, 13, 13},
+		{25, 25, 1},
+		{26, 0, 0},
+		{27, 1, 25},
+		{52, 0, 0},
+		{-1, 25, 1},
+		{-26, 0, 0},
+		{-27, 25, 1},
+		{-52, 0, 0},
+		{1000003, 17, 9},
+		{-1000003, 9, 17},
+		{26 * 1000000, 0, 0},
+		{-26 * 1000000, 0, 0},
+		{math.MaxInt64, 7, 19},
+		{math.MinInt64, 18, 8},
+	} {
+		if got := NormalizeShift(tc.shift); got != tc.normal {
+			t.Errorf("NormalizeShift(%d) = %d, want %d", tc.shift, got, tc.normal)
+		}
+		if got := InverseShift(tc.shift); got != tc.inverse {
+			t.Errorf("InverseShift(%d) = %d, want %d", tc.shift, got, tc.inverse)
+		}
+		if got := Encrypt(Encrypt("Hello, World!", tc.shift), tc.inverse); got != "Hello, World!" {
+			t.Errorf("shift %d then its inverse gave %q", tc.shift, got)
+		}
+	}
+}
+
+func TestEncryptStructVisitsEachStructOnce(t *testing.T) {
+	type Node struct {
+		Name string `caesar:"encrypt"`
+		Next *Node
+	}
+	cycle := &Node{Name: "abc"}
+	cycle.Next = cycle
+	if err := EncryptStruct(cycle, 1); err != nil || cycle.Name != "bcd" {
+		t.Errorf("self-referential struct: got %q, %v; want \"bcd\"", cycle.Name, err)
+	}
+	
+	type Pair struct {
+		Left, Right *Node
+	}
+	shared := &Node{Name: "abc"}
+	pair := &Pair{Left: shared, Right: shared}
+	if err := EncryptStruct(pair, 1); err != nil || shared.Name != "bcd" {
+		t.Errorf("struct shared by two fields: got %q, %v; want \"bcd\"", shared.Name, err)
+	}
+	
+	type Inner struct {
+		Secret string `caesar:"encrypt"`
+	}
+	type Outer struct {
+		Before string
+		Inner  Inner
+		Alias  *Inner
+	}
+	outer := &Outer{Inner: Inner{Secret: "abc"}}
+	outer.Alias = &outer.Inner
+	if err := EncryptStruct(outer, 1); err != nil || outer.Inner.Secret != "bcd" {
+		t.Errorf("embedded struct also reached by pointer: got %q, %v; want \"bcd\"", outer.Inner.Secret, err)
+	}
+}
+
+// asciiSample is printable ASCII text of every character class, about 10 KB long
+var asciiSample = strings.Repeat("The Quick Brown Fox, 42 jumps over the lazy dog! ~[]{}\t\n", 180)
+
+func TestShiftASCIIMatchesShiftRunes(t *testing.T) {
+	var everyByte strings.Builder
+	for b := 0; b < utf8.RuneSelf; b++ {
+		everyByte.WriteByte(byte(b))
+	}
+	for _, text := range []string{"", everyByte.String(), asciiSample} {
+		for upper := 0; upper < 26; upper++ {
+			for _, lower := range []int{0, upper, 25 - upper} {
+				if fast, slow := shiftASCII(text, upper, lower), shiftRunes(text, upper, lower); fast != slow {
+					t.Fatalf("shifts %d/%d: ASCII path gave %q, rune path %q", upper, lower, fast, slow)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkShiftASCII(b *testing.B) {
+	b.SetBytes(int64(len(asciiSample)))
+	for i := 0; i < b.N; i++ {
+		shiftASCII(asciiSample, 3, 3)
+	}
+}
+
+func BenchmarkShiftRunes(b *testing.B) {
+	b.SetBytes(int64(len(asciiSample)))
+	for i := 0; i < b.N; i++ {
+		shiftRunes(asciiSample, 3, 3)
+	}
+}
+
+// BenchmarkCipherEncryptParallel shares one Cipher across goroutines; it should report
+// 1 alloc/op, the returned string, with no contention to pool away
+func BenchmarkCipherEncryptParallel(b *testing.B) {
+	c := NewCipher(3)
+	b.SetBytes(int64(len(asciiSample)))
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Encrypt(asciiSample)
+		}
+	})
+}
+
+func TestShiftFromPassphraseIsDeterministic(t *testing.T) {
+	// Pinned values catch any change to the derivation, which would strand old messages
+	for _, tc := range []struct {
+		pass string
+		want int
+	}{
+		{"", 12},
+		{"hunter2", 25},
+		{"Hunter2", 1},
+		{"correct horse battery staple", 23},
+	} {
+		for i := 0; i < 3; i++ {
+			if got := ShiftFromPassphrase(tc.pass); got != tc.want {
+				t.Fatalf("ShiftFromPassphrase(%q) = %d on call %d, want %d", tc.pass, got, i+1, tc.want)
+			}
+		}
+		if got := applyCipher(EncryptWithPassphrase("Attack at dawn", tc.pass), InverseShift(tc.want)); got != "Attack at dawn" {
+			t.Errorf("passphrase %q round trip gave %q", tc.pass, got)
+		}
+	}
+}
+
+func TestEncrypt52RoundTrip(t *testing.T) {
+	// Letters wrap from z back to A, so case changes across the boundary
+	for _, tc := range []struct {
+		text  string
+		shift int
+		want  string
+	}{
+		{"Abc", 26, "aBC"},
+		{"Zz", 1, "aA"},
+		{"Hello, World!", 0, "Hello, World!"},
+		{"Hello, World!", 52, "Hello, World!"},
+		{"xyz", -1, "wxy"},
+	} {
+		if got := Encrypt52(tc.text, tc.shift); got != tc.want {
+			t.Errorf("Encrypt52(%q, %d) = %q, want %q", tc.text, tc.shift, got, tc.want)
+		}
+	}
+	
+	text := "The Quick Brown Fox jumps over the lazy dog, 123 é!"
+	for shift := -60; shift <= 60; shift++ {
+		if got := Decrypt52(Encrypt52(text, shift), shift); got != text {
+			t.Errorf("shift %d round trip gave %q", shift, got)
+		}
+	}
+}
+
+func TestPipelineUndoesThreeStages(t *testing.T) {
+	encrypt, decrypt := CaesarTransforms(5)
+	pipeline := new(Pipeline).
+		Add(encrypt, decrypt).
+		Add(Atbash, Atbash).
+		Add(reverseRunes, reverseRunes)
+	
+	text := "Attack at Dawn, 6 AM!"
+	ciphertext := pipeline.Apply(text)
+	if want := reverseRunes(Atbash(applyCipher(text, 5))); ciphertext != want {
+		t.Fatalf("Apply = %q, want %q", ciphertext, want)
+	}
+	if got := pipeline.Undo(ciphertext); got != text {
+		t.Errorf("Undo = %q, want %q", got, text)
+	}
+	
+	// Atbash and a shift do not commute, so the inverses only work in reverse order
+	wrong := ciphertext
+	for _, inverse := range []func(string) string{decrypt, Atbash, reverseRunes} {
+		wrong = inverse(wrong)
+	}
+	if wrong == text {
+		t.Error("undoing the stages in application order also recovered the text")
+	}
+}
+
+func TestAlphabetValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		alphabet []rune
+		want     error
+	}{
+		{"nil", nil, ErrEmptyAlphabet},
+		{"empty", []rune{}, ErrEmptyAlphabet},
+		{"duplicate letter", []rune("ABCA"), ErrDuplicateInAlphabet},
+		{"adjacent duplicate", []rune("αββγ"), ErrDuplicateInAlphabet},
+	} {
+		if _, err := EncryptWithAlphabet("ABC", 1, tc.alphabet); !errors.Is(err, tc.want) {
+			t.Errorf("EncryptWithAlphabet with %s alphabet: got %v, want %v", tc.name, err, tc.want)
+		}
+		if _, err := DecryptWithAlphabet("ABC", 1, tc.alphabet); !errors.Is(err, tc.want) {
+			t.Errorf("DecryptWithAlphabet with %s alphabet: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+	
+	ciphertext, err := EncryptWithAlphabet("CAB!", 1, []rune("ABC"))
+	if err != nil || ciphertext != "ABC!" {
+		t.Fatalf("EncryptWithAlphabet with a valid alphabet = %q, %v; want \"ABC!\"", ciphertext, err)
+	}
+	if plaintext, err := DecryptWithAlphabet(ciphertext, 1, []rune("ABC")); err != nil || plaintext != "CAB!" {
+		t.Errorf("DecryptWithAlphabet = %q, %v; want \"CAB!\"", plaintext, err)
+	}
+}
+
+func TestRotateInAlphabetStaysInAlphabet(t *testing.T) {
+	for name, alphabet := range alphabetPresets {
+		size := len(alphabet)
+		members := make(map[rune]bool, size)
+		for _, letter := range alphabet {
+			members[letter] = true
+		}
+		lower := make(map[rune]bool, size)
+		for _, letter := range strings.ToLower(string(alphabet)) {
+			lower[letter] = true
+		}
+		
+		shifts := []int{math.MaxInt, math.MinInt, 1000003, -1000003}
+		for shift := -3 * size; shift <= 3*size; shift++ {
+			shifts = append(shifts, shift)
+		}
+		for _, shift := range shifts {
+			ciphertext, err := EncryptWithAlphabet(string(alphabet), shift, alphabet)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			for _, char := range ciphertext {
+				if !members[char] {
+					t.Fatalf("%s, shift %d: %q is outside the alphabet", name, shift, char)
+				}
+			}
+			if utf8.RuneCountInString(ciphertext) != size {
+				t.Fatalf("%s, shift %d: got %d runes, want %d", name, shift, utf8.RuneCountInString(ciphertext), size)
+			}
+			
+			// Lower-case forms must land among the lower-case forms
+			for _, char := range rotateInAlphabet(strings.ToLower(string(alphabet)), shift, alphabet) {
+				if !members[char] && !lower[char] {
+					t.Fatalf("%s, shift %d: lower-case input gave %q, outside both cases of the alphabet", name, shift, char)
+				}
+			}
+		}
+	}
+}
+
+func TestFoldThenRestoreRoundTrip(t *testing.T) {
+	// ı and ſ fold into A-Z, and the Kelvin sign into k; shifting any of them onto an
+	// ASCII letter would lose the original rune
+	for _, text := range []string{"Hello, World!", "ıt ſo", "Iı Sſ \u212A k", "é€😀 Zz", ""} {
+		for _, shift := range []int{0, 3, 13, 25, -1, 55} {
+			ciphertext, err := EncryptWithOptions(text, shift, Options{CaseMode: FoldThenRestore})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if preserved, _ := EncryptWithOptions(text, shift, Options{}); ciphertext != preserved {
+				t.Errorf("shift %d of %q: fold gave %q, preserve gave %q", shift, text, ciphertext, preserved)
+			}
+			if got, _ := EncryptWithOptions(ciphertext, InverseShift(shift), Options{CaseMode: FoldThenRestore}); got != text {
+				t.Errorf("shift %d of %q: round trip gave %q", shift, text, got)
+			}
+		}
+	}
+}
+
+func TestDualShiftRoundTrip(t *testing.T) {
+	if got := EncryptDualShift("AbZz", 1, -1); got != "BaAy" {
+		t.Errorf("EncryptDualShift(\"AbZz\", 1, -1) = %q, want \"BaAy\"", got)
+	}
+	
+	text := "Hello, World! ünïcode Zz Aa 42"
+	for _, shifts := range [][2]int{{3, -5}, {-1, 25}, {-27, 40}, {13, 0}, {0, -13}, {7, 7}, {-100, 100}} {
+		upper, lower := shifts[0], shifts[1]
+		ciphertext := EncryptDualShift(text, upper, lower)
+		if got := DecryptDualShift(ciphertext, upper, lower); got != text {
+			t.Errorf("shifts %d/%d: round trip gave %q", upper, lower, got)
+		}
+		if upper == lower && ciphertext != Encrypt(text, upper) {
+			t.Errorf("equal shifts %d: got %q, want Encrypt's %q", upper, ciphertext, Encrypt(text, upper))
+		}
+	}
+}
+
+func TestEncryptRegex(t *testing.T) {
+	log := "ts=1 user=alice ip=10.0.0.1 msg=Login ok\nts=2 user=Bob ip=10.0.0.2 msg=Logout\n"
+	for _, tc := range []struct {
+		name, pattern, want string
+	}{
+		{"capture group keeps the rest of the match", `user=(\w+)`, "ts=1 user=dolfh ip=10.0.0.1 msg=Login ok\nts=2 user=Ere ip=10.0.0.2 msg=Logout\n"},
+		{"no groups shifts the whole match", `user=\w+`, "ts=1 xvhu=dolfh ip=10.0.0.1 msg=Login ok\nts=2 xvhu=Ere ip=10.0.0.2 msg=Logout\n"},
+		{"nested groups shift once", `((u)ser)=(\w+)`, "ts=1 xvhu=dolfh ip=10.0.0.1 msg=Login ok\nts=2 xvhu=Ere ip=10.0.0.2 msg=Logout\n"},
+		{"unmatched alternative groups are skipped", `user=(\w+)|msg=(\w+)`, "ts=1 user=dolfh ip=10.0.0.1 msg=Orjlq ok\nts=2 user=Ere ip=10.0.0.2 msg=Orjrxw\n"},
+		{"no match leaves the text alone", `zzz`, log},
+		{"empty matches leave the text alone", `x*`, log},
+	} {
+		if got := EncryptRegex(log, 3, regexp.MustCompile(tc.pattern)); got != tc.want {
+			t.Errorf("%s: EncryptRegex with %q = %q, want %q", tc.name, tc.pattern, got, tc.want)
+		}
+	}
+	
+	// The inverse shift undoes it when the pattern still matches the ciphertext
+	re := regexp.MustCompile(`user=(\w+)`)
+	if got := EncryptRegex(EncryptRegex(log, 3, re), InverseShift(3), re); got != log {
+		t.Errorf("round trip gave %q", got)
+	}
+}
+
+func BenchmarkEncryptRegex(b *testing.B) {
+	logs := strings.Repeat("ts=1 user=alice ip=10.0.0.1 msg=Login ok\n", 10000)
+	re := regexp.MustCompile(`user=(\w+)`)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		EncryptRegex(logs, 3, re)
+	}
+}
+
+func BenchmarkEncryptWholeLog(b *testing.B) {
+	logs := strings.Repeat("ts=1 user=alice ip=10.0.0.1 msg=Login ok\n", 10000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Encrypt(logs, 3)
+	}
+}
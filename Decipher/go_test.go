@@ -0,0 +1,721 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestSelfTest(t *testing.T) {
+	if !runSelfTest(io.Discard) {
+		t.Fatal("selftest failed; run 'go run go.go selftest' for details")
+	}
+}
+
+func TestNormalizeShift(t *testing.T) {
+	for _, tc := range []struct{ shift, want int }{
+		{0, 0}, {25, 25}, {26, 0}, {52, 0}, {-26, 0}, {-52, 0}, {27, 1}, {-1, 25}, {-27, 25},
+		{1000003, 17}, {-1000003, 9}, {26 * 1000000, 0}, {-26 * 1000000, 0}, {math.MaxInt64, 7}, {math.MinInt64, 18},
+	} {
+		if got := NormalizeShift(tc.shift); got != tc.want {
+			t.Errorf("NormalizeShift(%d) = %d, want %d", tc.shift, got, tc.want)
+		}
+	}
+}
+
+func TestInverseShift(t *testing.T) {
+	for shift := -55; shift <= 55; shift++ {
+		if inverse := InverseShift(shift); inverse < 0 || inverse >= 26 || (shift+inverse)%26 != 0 {
+			t.Errorf("InverseShift(%d) = %d, want the shift in 0-25 that undoes it", shift, inverse)
+		}
+	}
+}
+
+func TestLettersOnly(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"Hello, World!", "HelloWorld"},
+		{"a1 b2\tc3\n", "abc"},
+		{"é€😀ıſ", ""},
+		{"", ""},
+		{"Zz-Aa", "ZzAa"},
+	} {
+		if got := lettersOnly(tc.in); got != tc.want {
+			t.Errorf("lettersOnly(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestDecipherPreservesNonLetters(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"0123456789", "0123456789"},
+		{" \t\n", " \t\n"},
+		{"!?.,;:'\"-()[]{}", "!?.,;:'\"-()[]{}"},
+		{"é€😀", "é€😀"},
+		{"D😀E", "A😀B"},
+	} {
+		if got := decipherWithShift(tc.in, 3); got != tc.want {
+			t.Errorf("decipherWithShift(%q, 3) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestOptionsScore(t *testing.T) {
+	// Tab-heavy text only earns the space bonus once its whitespace is collapsed, and
+	// stray single letters stop counting as words once they are ignored
+	tabbed := "The\t\tcat\t\tsat\t\ton\t\tthe\t\tmat"
+	gibberish := "Q a X i Z zq"
+	for _, tc := range []struct {
+		name string
+		opts Options
+		text string
+		want float64
+	}{
+		{"default options", Options{}, gibberish, scoreDecipheredText(gibberish)},
+		{"whitespace normalization", Options{NormalizeWhitespace: true}, tabbed, scoreDecipheredText(tabbed) + 2},
+		{"single letters ignored", Options{IgnoreSingleLetters: true}, gibberish, scoreDecipheredText(gibberish) - 2},
+	} {
+		if got := tc.opts.score(tc.text); got != tc.want {
+			t.Errorf("%s: score(%q) = %v, want %v", tc.name, tc.text, got, tc.want)
+		}
+	}
+	if bonus := spaceBonus(tabbed); bonus != 0 {
+		t.Errorf("spaceBonus(%q) = %v, want 0", tabbed, bonus)
+	}
+}
+
+func TestEnglishConfidencePercent(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		want float64
+	}{
+		{selfTestSample, 100},
+		{"Xqzv bnmt lkjh wpr", 0},
+	} {
+		if got := EnglishConfidencePercent(tc.text); got != tc.want {
+			t.Errorf("EnglishConfidencePercent(%q) = %v, want %v", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestLearnedFrequencies(t *testing.T) {
+	// A table learned from domain text must break what the general English table misses
+	domainSample := "The quiz on zinc oxide: oxidize the zinc, quiz the jazz band. Zinc oxide quizzes fizz. Oxidized zinc and quartz, oxygen, xylene and zeolite."
+	learned := LearnFrequencies(domainSample)
+	ciphertext := decipherWithShift("zinc oxide quiz", InverseShift(7))
+	var counts FrequencyAccumulator
+	io.WriteString(&counts, ciphertext)
+	if shift := GuessShift(counts.Counts()); shift == 7 {
+		t.Fatal("the general English table already recovers the shift; the sample no longer tests learning")
+	}
+	if _, shift, err := FrequencyAnalysisWithOptions(ciphertext, Options{Frequencies: &learned}); err != nil || shift != 7 {
+		t.Errorf("learned table: got shift %d, error %v; want shift 7", shift, err)
+	}
+}
+
+func TestHighlightWords(t *testing.T) {
+	const want = "*tHe* Dog, *AND* *ıt*! *wITh*"
+	if got := HighlightWords("tHe Dog, AND ıt! wITh", false); got != want {
+		t.Errorf("HighlightWords = %q, want %q", got, want)
+	}
+}
+
+func TestMappingTable(t *testing.T) {
+	for _, tc := range []struct {
+		shift int
+		want  string
+	}{
+		{0, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"},
+		{13, "NOPQRSTUVWXYZABCDEFGHIJKLM"},
+		{33, "HIJKLMNOPQRSTUVWXYZABCDEFG"},
+	} {
+		plain, cipher := MappingTable(tc.shift)
+		if plain != "ABCDEFGHIJKLMNOPQRSTUVWXYZ" || cipher != tc.want {
+			t.Errorf("MappingTable(%d) = %q, %q; want the alphabet and %q", tc.shift, plain, cipher, tc.want)
+		}
+	}
+	
+	// The table must invert through ApplyMapping
+	plain, cipher := MappingTable(7)
+	inverse := make(map[rune]rune)
+	for i := range cipher {
+		inverse[rune(cipher[i])] = rune(plain[i])
+	}
+	if got := ApplyMapping(decipherWithShift(selfTestSample, InverseShift(7)), inverse); got != selfTestSample {
+		t.Errorf("ApplyMapping with the inverted shift 7 table = %q, want %q", got, selfTestSample)
+	}
+}
+
+func TestParseMagicHeader(t *testing.T) {
+	for _, tc := range []struct {
+		in, wantBody string
+		wantShift    int
+		wantOK       bool
+	}{
+		{"C3\r\nKhoor\n", "Khoor\n", 3, true},
+		{"C26\nKhoor", "", 0, false},
+		{"Cats\nare great", "", 0, false},
+	} {
+		body, shift, ok := ParseMagicHeader(tc.in)
+		if ok != tc.wantOK || ok && (body != tc.wantBody || shift != tc.wantShift) {
+			t.Errorf("ParseMagicHeader(%q) = %q, %d, %v; want %q, %d, %v", tc.in, body, shift, ok, tc.wantBody, tc.wantShift, tc.wantOK)
+		}
+	}
+}
+
+func TestKeyLetters(t *testing.T) {
+	if shift := KeyLetterToShift('?'); shift != -1 {
+		t.Errorf("KeyLetterToShift('?') = %d, want -1", shift)
+	}
+	for shift := 0; shift < 26; shift++ {
+		letter := ShiftToKeyLetter(shift)
+		if letter != 'A'+rune(shift) || ShiftToKeyLetter(shift+26) != letter {
+			t.Errorf("ShiftToKeyLetter(%d) = %q, want %q for it and shift %d", shift, letter, 'A'+rune(shift), shift+26)
+		}
+		if KeyLetterToShift(letter) != shift || KeyLetterToShift(unicode.ToLower(letter)) != shift {
+			t.Errorf("KeyLetterToShift(%q) and its lower case do not both give %d", letter, shift)
+		}
+	}
+}
+
+func TestCountNgrams(t *testing.T) {
+	// Counting must carry across word breaks
+	tables, err := countNgrams(strings.NewReader("The then."), []int{2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]map[string]int{
+		2: {"TH": 2, "HE": 2, "ET": 1, "EN": 1},
+		3: {"THE": 2, "HET": 1, "ETH": 1, "HEN": 1},
+	}
+	for n, counts := range want {
+		if len(tables[n]) != len(counts) {
+			t.Errorf("%d-grams: got %v, want %v", n, tables[n], counts)
+			continue
+		}
+		for gram, count := range counts {
+			if tables[n][gram] != count {
+				t.Errorf("%d-gram %q counted %d times, want %d", n, gram, tables[n][gram], count)
+			}
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	outFile := filepath.Join(dir, "out.txt")
+	if err := os.WriteFile(outFile, []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	
+	// A write that fails partway must leave the previous output untouched
+	err := writeFileAtomic(outFile, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errors.New("simulated crash")
+	})
+	kept, _ := os.ReadFile(outFile)
+	entries, _ := os.ReadDir(dir)
+	if err == nil || string(kept) != "original" || len(entries) != 1 {
+		t.Errorf("interrupted write: error %v, file %q, %d entries; want an error, the old file and no temporary left", err, kept, len(entries))
+	}
+	
+	err = writeFileAtomic(outFile, func(w io.Writer) error {
+		_, err := io.WriteString(w, "replaced")
+		return err
+	})
+	if replaced, _ := os.ReadFile(outFile); err != nil || string(replaced) != "replaced" {
+		t.Errorf("completed write: error %v, file %q; want %q", err, replaced, "replaced")
+	}
+	
+	// Replacing a private file must not widen its permissions, and a new file must not
+	// get more than 0644
+	if err := os.Chmod(outFile, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(outFile, func(w io.Writer) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(outFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("replacing a 0600 file left mode %v, error %v", info.Mode().Perm(), err)
+	}
+	newFile := filepath.Join(dir, "new.txt")
+	if err := writeFileAtomic(newFile, func(w io.Writer) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(newFile); err != nil || info.Mode().Perm()&^0644 != 0 {
+		t.Errorf("new file has mode %v, error %v; want at most 0644", info.Mode().Perm(), err)
+	}
+}
+
+// TestReadInputPipedRoundTrip feeds multi-line ciphertext to readInput through a file
+// standing in for piped stdin; the decryption must match the original byte for byte
+func TestReadInputPipedRoundTrip(t *testing.T) {
+	multiline := selfTestSample + "\nIt was the season of light.\n\nWe had everything before us."
+	for _, tc := range []struct{ name, ending string }{
+		{"with a trailing newline", "\n"},
+		{"without a trailing newline", ""},
+		{"ending in CRLF and a blank line", "\r\n\n"},
+	} {
+		original := multiline + tc.ending
+		stdinFile := filepath.Join(t.TempDir(), "cipher.txt")
+		if err := os.WriteFile(stdinFile, []byte(decipherWithShift(original, InverseShift(5))), 0644); err != nil {
+			t.Fatal(err)
+		}
+		stdin, err := os.Open(stdinFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		savedStdin := os.Stdin
+		os.Stdin = stdin
+		ciphertext, _, err := readInput(bufio.NewScanner(stdin), "", false)
+		os.Stdin = savedStdin
+		stdin.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := Decrypt(ciphertext, 5); got != original {
+			t.Errorf("%s: round trip gave %q, want %q", tc.name, got, original)
+		}
+	}
+}
+
+func TestTrimLineEnding(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"Hello\n", "Hello"},
+		{"Hello\r\n", "Hello"},
+		{"Hello\n\n", "Hello\n"},
+		{"Hello\r", "Hello\r"},
+		{"Hello", "Hello"},
+		{"", ""},
+	} {
+		if got := trimLineEnding(tc.in); got != tc.want {
+			t.Errorf("trimLineEnding(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// shortMessages returns n short ciphertexts of varying text and shift, the workload of
+// breaking a large batch one message at a time
+func shortMessages(n int) []string {
+	messages := make([]string, n)
+	for i := range messages {
+		plaintext := fmt.Sprintf("Meet me at the old mill at %d, bring the map and the lantern.", i%24)
+		messages[i] = decipherWithShift(plaintext, InverseShift(i%26))
+	}
+	return messages
+}
+
+// BenchmarkBreak100kWithoutAnalyzer counts letters through calculateFrequencies, which
+// allocates a fresh map per message
+func BenchmarkBreak100kWithoutAnalyzer(b *testing.B) {
+	messages := shortMessages(100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, message := range messages {
+			freq := calculateFrequencies(message)
+			var counts [26]int
+			for letter, count := range freq {
+				counts[letter-'A'] = count
+			}
+			shift := GuessShift(counts)
+			decipherWithShift(message, shift)
+		}
+	}
+}
+
+func BenchmarkBreak100kWithAnalyzer(b *testing.B) {
+	messages := shortMessages(100000)
+	var analyzer Analyzer
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, message := range messages {
+			analyzer.Break(message)
+		}
+	}
+}
+
+// selfTestPassage is a longer English passage on which both breakers are expected to
+// succeed at every shift
+const selfTestPassage = selfTestSample + " It was the epoch of belief, it was the epoch of incredulity, it was the season of Light, it was the season of Darkness, it was the spring of hope, it was the winter of despair, we had everything before us, we had nothing before us, we were all going direct to Heaven, we were all going direct the other way."
+
+// selfTestCorpus holds short English passages of different styles on which
+// scoreDecipheredText must rank the correct decryption above every other shift
+var selfTestCorpus = []struct{ name, text string }{
+	{"Dickens", selfTestSample},
+	{"Melville", "Call me Ishmael. Some years ago, never mind how long precisely, having little or no money in my purse, and nothing particular to interest me on shore, I thought I would sail about a little and see the watery part of the world."},
+	{"Austen", "It is a truth universally acknowledged, that a single man in possession of a good fortune, must be in want of a wife."},
+	{"Lincoln", "Four score and seven years ago our fathers brought forth on this continent, a new nation, conceived in Liberty, and dedicated to the proposition that all men are created equal."},
+	{"Tolstoy", "Happy families are all alike; every unhappy family is unhappy in its own way."},
+	{"Tolkien", "In a hole in the ground there lived a hobbit. Not a nasty, dirty, wet hole, filled with the ends of worms and an oozy smell, nor yet a dry, bare, sandy hole with nothing in it to sit down on or to eat: it was a hobbit-hole, and that means comfort."},
+	{"Shakespeare", "To be, or not to be, that is the question: whether it is nobler in the mind to suffer the slings and arrows of outrageous fortune, or to take arms against a sea of troubles."},
+	{"pangram", "The quick brown fox jumps over the lazy dog and then it runs to the river with the other dogs."},
+}
+
+func TestScorerRanksCorpus(t *testing.T) {
+	// Each passage is encrypted at a shift drawn from a fixed seed, and the correct
+	// decryption must score strictly above every other shift
+	random := rand.New(rand.NewSource(1))
+	for _, passage := range selfTestCorpus {
+		shift := random.Intn(25) + 1
+		ciphertext := decipherWithShift(passage.text, InverseShift(shift))
+		correctScore := scoreDecipheredText(decipherWithShift(ciphertext, shift))
+		for candidate := 0; candidate < 26; candidate++ {
+			if score := scoreDecipheredText(decipherWithShift(ciphertext, candidate)); candidate != shift && score >= correctScore {
+				t.Errorf("%s at shift %d: shift %d scores %v, not below the correct %v", passage.name, shift, candidate, score, correctScore)
+			}
+		}
+	}
+}
+
+// longText is about 10 KB of English for the scoring benchmarks
+var longText = strings.Repeat(selfTestPassage+" ", 25)
+
+func TestScoreDecipheredTextMatchesTokenizedScore(t *testing.T) {
+	texts := []string{"", "   ", "tHe Dog, AND ıt! wITh ſo", "a\tb\nthe  of", longText}
+	for _, passage := range selfTestCorpus {
+		texts = append(texts, passage.text)
+	}
+	for _, text := range texts {
+		for shift := 0; shift < 26; shift++ {
+			candidate := decipherWithShift(text, shift)
+			if fast, slow := scoreDecipheredText(candidate), scoreDecipheredTextWith(candidate, strings.Fields); fast != slow {
+				t.Fatalf("scores differ for %q: in place %v, tokenized %v", candidate, fast, slow)
+			}
+		}
+	}
+}
+
+// BenchmarkScoreTokenized is the allocating path scoreDecipheredText used to take
+func BenchmarkScoreTokenized(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		scoreDecipheredTextWith(longText, strings.Fields)
+	}
+}
+
+func BenchmarkScoreInPlace(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		scoreDecipheredText(longText)
+	}
+}
+
+func BenchmarkBruteForceLongText(b *testing.B) {
+	ciphertext := decipherWithShift(longText, InverseShift(7))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		breakCipherBruteForce(ciphertext)
+	}
+}
+
+func TestIncrementalDecryptorMatchesDecipherWithShift(t *testing.T) {
+	ciphertext := decipherWithShift(selfTestPassage+" é€😀 Zz", InverseShift(9))
+	slider := NewIncrementalDecryptor(ciphertext, -3)
+	if got, want := slider.Current(), decipherWithShift(ciphertext, 23); got != want {
+		t.Fatalf("initial shift -3: got %q, want %q", got, want)
+	}
+	for _, shift := range []int{0, 1, 2, 25, 9, 9, 13, -1, 40, 0} {
+		slider.SetShift(shift)
+		if slider.Shift() != NormalizeShift(shift) {
+			t.Fatalf("SetShift(%d): Shift() is %d, want %d", shift, slider.Shift(), NormalizeShift(shift))
+		}
+		if got, want := slider.Current(), decipherWithShift(ciphertext, shift); got != want {
+			t.Fatalf("SetShift(%d): got %q, want %q", shift, got, want)
+		}
+	}
+}
+
+// BenchmarkSliderFromScratch decrypts the original text again at every step of a
+// slider sweeping through all 26 shifts
+func BenchmarkSliderFromScratch(b *testing.B) {
+	ciphertext := decipherWithShift(longText, InverseShift(7))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for shift := 0; shift < 26; shift++ {
+			decipherWithShift(ciphertext, shift)
+		}
+	}
+}
+
+func BenchmarkSliderIncremental(b *testing.B) {
+	ciphertext := decipherWithShift(longText, InverseShift(7))
+	decryptor := NewIncrementalDecryptor(ciphertext, 0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for shift := 0; shift < 26; shift++ {
+			decryptor.SetShift(shift)
+			decryptor.Current()
+		}
+	}
+}
+
+// TestSingleWordFastPathMatchesFullScoring checks that the fast path picks exactly what
+// full scoring picks. Inputs are common words at random shifts, some padded with
+// whitespace, and random short strings with non-ASCII letters that fold into A-Z.
+func TestSingleWordFastPathMatchesFullScoring(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	words := []string{"the", "AND", "of", "i", "A", "wIth", "have", "ıt", "ſo", "The,", "(to)", "x", "Hello", "Khoor", "wkh", ""}
+	symbols := []rune("abcxyzQRSTUVW ıſé!,\n")
+	inputs := []string{"", " ", "Wkh", "KHOOR", "Abcdefghijklmnopqrs", "Abcdefghijklmnopqrst"}
+	for i := 0; i < 3000; i++ {
+		if i%2 == 0 {
+			input := decipherWithShift(words[random.Intn(len(words))], random.Intn(26))
+			if random.Intn(3) == 0 {
+				input = " " + input + "\n"
+			}
+			inputs = append(inputs, input)
+			continue
+		}
+		var builder strings.Builder
+		for n := random.Intn(25); n > 0; n-- {
+			builder.WriteRune(symbols[random.Intn(len(symbols))])
+		}
+		inputs = append(inputs, builder.String())
+	}
+	for _, input := range inputs {
+		fastText, fastShift := breakCipherBruteForce(input)
+		fullText, fullShift := BruteForceWithScorer(input, scoreDecipheredText)
+		if fastText != fullText || fastShift != fullShift {
+			t.Errorf("%q: fast path gave shift %d (%q), full scoring shift %d (%q)", input, fastShift, fastText, fullShift, fullText)
+		}
+	}
+}
+
+// singleWords covers lone words on both sides of unspacedMinLetters: the fast path
+// handles up to 19 letters and full scoring takes over at 20
+var singleWords = []struct{ name, ciphertext string }{
+	{"3 letters", "Wkh"},
+	{"5 letters", "KHOOR"},
+	{"19 letters", "Abcdefghijklmnopqrs"},
+	{"20 letters", "Abcdefghijklmnopqrst"},
+}
+
+func BenchmarkSingleWordFullScoring(b *testing.B) {
+	for _, word := range singleWords {
+		b.Run(word.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				BruteForceWithScorer(word.ciphertext, scoreDecipheredText)
+			}
+		})
+	}
+}
+
+func BenchmarkSingleWordBreak(b *testing.B) {
+	for _, word := range singleWords {
+		b.Run(word.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				breakCipherBruteForce(word.ciphertext)
+			}
+		})
+	}
+}
+
+func TestKeyCodesRoundTrip(t *testing.T) {
+	if got := EncodeKey(3, English); got != "AEBWK3SN" {
+		t.Fatalf("EncodeKey(3, English) = %q, want %q", got, "AEBWK3SN")
+	}
+	for _, lang := range []Language{English, German, Spanish, French} {
+		for shift := -26; shift < 52; shift++ {
+			code := EncodeKey(shift, lang)
+			gotShift, gotLang, err := DecodeKey(strings.ToLower(" " + code + "\n"))
+			if err != nil || gotShift != NormalizeShift(shift) || gotLang != lang {
+				t.Errorf("DecodeKey(%q) = %d, %q, %v; want %d, %q", code, gotShift, gotLang, err, NormalizeShift(shift), lang)
+			}
+		}
+	}
+}
+
+func TestDecodeKeyRejectsDamagedCodes(t *testing.T) {
+	for _, tc := range []struct{ name, code string }{
+		{"mistyped", "AEBWK3SM"},
+		{"truncated", EncodeKey(3, English)[:7]},
+		{"unsupported language", EncodeKey(3, "xx")},
+		{"empty", ""},
+	} {
+		if _, _, err := DecodeKey(tc.code); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%s code %q: got error %v, want ErrInvalidKey", tc.name, tc.code, err)
+		}
+	}
+}
+
+func TestRelateTexts(t *testing.T) {
+	for _, tc := range []struct {
+		name, plaintext, ciphertext string
+		want                        int
+		wantErr                     error
+	}{
+		{"encryption of a sentence", selfTestSample, decipherWithShift(selfTestSample, InverseShift(17)), 17, nil},
+		{"identical text", "Hello", "Hello", 0, nil},
+		{"inconsistent shifts", "Hello, world", "Khoor, zrumg", 0, ErrUnrelated},
+		{"differing lengths", "Hello", "Khoor!", 0, ErrUnrelated},
+		{"differing invalid bytes", "a\xffb", "d\xfee", 0, ErrUnrelated},
+		{"matching invalid bytes", "a\xffb\xe2", "d\xffe\xe2", 3, nil},
+	} {
+		shift, err := RelateTexts(tc.plaintext, tc.ciphertext)
+		if tc.wantErr != nil {
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("%s: got error %v, want %v", tc.name, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil || shift != tc.want {
+			t.Errorf("%s: got shift %d, error %v; want shift %d", tc.name, shift, err, tc.want)
+		}
+	}
+}
+
+func TestBreakersRecoverShift(t *testing.T) {
+	const shift = 7
+	paragraphs := selfTestSample + "\nIt was the season of light.\n\nWe had everything before us, we had nothing before us.\n"
+	unspaced := strings.ReplaceAll(selfTestSample, " ", "")
+	for _, tc := range []struct{ name, plaintext string }{
+		{"sentence", selfTestSample},
+		{"multiple paragraphs", paragraphs},
+	} {
+		ciphertext := decipherWithShift(tc.plaintext, InverseShift(shift))
+		if got, gotShift := breakCipherBruteForce(ciphertext); got != tc.plaintext || gotShift != shift {
+			t.Errorf("%s: brute force gave shift %d, %q", tc.name, gotShift, got)
+		}
+		if got, gotShift := breakCipherFrequencyAnalysis(ciphertext); got != tc.plaintext || gotShift != shift {
+			t.Errorf("%s: frequency analysis gave shift %d, %q", tc.name, gotShift, got)
+		}
+		if _, gotShift, err := FrequencyAnalysisWithOptions(ciphertext, Options{IgnoreSingleLetters: true}); err != nil || gotShift != shift {
+			t.Errorf("%s: frequency analysis ignoring single letters gave shift %d, error %v", tc.name, gotShift, err)
+		}
+		if result := AutoBreak(ciphertext); result.Shift != shift || result.Plaintext != tc.plaintext {
+			t.Errorf("%s: AutoBreak gave shift %d, %q", tc.name, result.Shift, result.Plaintext)
+		}
+	}
+	if _, gotShift := breakCipherBruteForce(decipherWithShift(unspaced, InverseShift(shift))); gotShift != shift {
+		t.Errorf("unspaced: brute force gave shift %d, want %d", gotShift, shift)
+	}
+}
+
+func TestBreakersRecoverEveryShift(t *testing.T) {
+	// On long, ordinary English both breakers must find the right key at every shift
+	for shift := 0; shift < 26; shift++ {
+		ciphertext := decipherWithShift(selfTestPassage, InverseShift(shift))
+		if _, got := breakCipherBruteForce(ciphertext); got != shift {
+			t.Errorf("brute force gave shift %d, want %d", got, shift)
+		}
+		if _, got := breakCipherFrequencyAnalysis(ciphertext); got != shift {
+			t.Errorf("frequency analysis gave shift %d, want %d", got, shift)
+		}
+	}
+}
+
+func TestAutoBreakConfidence(t *testing.T) {
+	unspaced := strings.ReplaceAll(selfTestSample, " ", "")
+	if result := AutoBreak(decipherWithShift(unspaced, InverseShift(5))); result.Shift != 5 || result.Confidence < autoBreakMinConfidence {
+		t.Errorf("unspaced English: shift %d, confidence %v; want shift 5 and at least %v", result.Shift, result.Confidence, autoBreakMinConfidence)
+	}
+	if result := AutoBreak("Xqzvbnmtlkjhwprsdfghjklqwerty"); result.Confidence >= autoBreakMinConfidence {
+		t.Errorf("unspaced gibberish: confidence %v, want below %v", result.Confidence, autoBreakMinConfidence)
+	}
+}
+
+func TestBreakClassical(t *testing.T) {
+	for _, tc := range []struct{ name, ciphertext, want string }{
+		{"Atbash", Atbash(selfTestSample), "atbash"},
+		{"Caesar", decipherWithShift(selfTestSample, InverseShift(7)), "caesar:7"},
+	} {
+		if got, method := BreakClassical(tc.ciphertext); method != tc.want || got != selfTestSample {
+			t.Errorf("%s: method %q, plaintext %q; want %q", tc.name, method, got, tc.want)
+		}
+	}
+}
+
+func TestCompareBreakersTie(t *testing.T) {
+	// The comparison must explain a tie the way each breaker resolved it
+	ciphertext := "Xlmw mw e wigvix qiwweki"
+	scorer := scorers["vowels"]
+	_, bruteShift := BruteForceWithScorer(ciphertext, scorer)
+	_, freqShift, _ := FrequencyAnalysisWithOptions(ciphertext, Options{})
+	comparison := CompareBreakers(ciphertext, scorer, Options{}, bruteShift, freqShift)
+	if len(comparison.FrequencyOrder) != 26 {
+		t.Errorf("FrequencyOrder has %d shifts, want 26", len(comparison.FrequencyOrder))
+	}
+	if len(comparison.BruteForceTied) == 0 || comparison.BruteForceTied[0] != bruteShift {
+		t.Errorf("BruteForceTied = %v, want it to start with %d", comparison.BruteForceTied, bruteShift)
+	}
+	if !slices.Contains(comparison.FrequencyTied, freqShift) {
+		t.Errorf("FrequencyTied = %v, want it to contain %d", comparison.FrequencyTied, freqShift)
+	}
+}
+
+func TestBreakFrequencyErrors(t *testing.T) {
+	for _, tc := range []struct {
+		ciphertext string
+		want       error
+	}{
+		{"123 !?", ErrNoLetters},
+		{"Wkh", ErrTooShort},
+	} {
+		if _, _, err := BreakFrequency(tc.ciphertext); !errors.Is(err, tc.want) {
+			t.Errorf("BreakFrequency(%q): got error %v, want %v", tc.ciphertext, err, tc.want)
+		}
+	}
+}
+
+func TestDetectLanguageAndShift(t *testing.T) {
+	frenchSample := "Le petit prince était assis sur une pierre et regardait le coucher du soleil. Il pensait à sa fleur, qui était restée seule sur sa planète, et il se demandait si le mouton l'avait mangée pendant la nuit."
+	for _, tc := range []struct {
+		plaintext string
+		want      Language
+	}{
+		{frenchSample, French},
+		{selfTestPassage, English},
+	} {
+		lang, shift, _ := DetectLanguageAndShift(decipherWithShift(tc.plaintext, InverseShift(11)), []Language{English, French})
+		if lang != tc.want || shift != 11 {
+			t.Errorf("got %s at shift %d, want %s at shift 11", lang, shift, tc.want)
+		}
+	}
+}
+
+func TestBreakersLogEveryCandidate(t *testing.T) {
+	ciphertext := decipherWithShift(selfTestSample, InverseShift(7))
+	english := letterFrequencies[English]
+	for _, tc := range []struct {
+		name  string
+		run   func(Options)
+		field string
+	}{
+		{"brute force", func(opts Options) { breakCipherBruteForceWith(ciphertext, opts) }, "score"},
+		{"brute force single word", func(opts Options) { breakCipherBruteForceWith("Wkh", opts) }, "score"},
+		{"custom scorer", func(opts Options) { bruteForceWith(ciphertext, scorers["vowels"], opts) }, "score"},
+		{"frequency analysis", func(opts Options) { FrequencyAnalysisWithOptions(ciphertext, opts) }, "score"},
+		{"frequency table", func(opts Options) {
+			opts.Frequencies = &english
+			FrequencyAnalysisWithOptions(ciphertext, opts)
+		}, "chi_squared"},
+	} {
+		var buf strings.Builder
+		tc.run(Options{Logger: slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))})
+		scored, selected := 0, 0
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if strings.Contains(line, `msg="scored candidate"`) && strings.Contains(line, " "+tc.field+"=") {
+				scored++
+			} else if strings.Contains(line, `msg="selected shift"`) {
+				selected++
+			}
+		}
+		if scored != 26 || selected != 1 {
+			t.Errorf("%s: logged %d candidates and %d selections, want 26 and 1:\n%s", tc.name, scored, selected, buf.String())
+		}
+	}
+}